@@ -0,0 +1,239 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 共享文件(夹)的可见范围
+const (
+	VisibilityPrivate  = "private"  // 仅所有者
+	VisibilityUsers    = "users"    // 所有者和指定用户
+	VisibilityGroup    = "group"    // 同一班级/小组的成员（管理员设置的 class_group）
+	VisibilityEveryone = "everyone" // 所有登录用户（没有规则时的默认值）
+	VisibilityLink     = "link"     // 不在列表中显示，只能通过分享链接匿名访问
+)
+
+func isValidVisibility(v string) bool {
+	switch v {
+	case VisibilityPrivate, VisibilityUsers, VisibilityGroup, VisibilityEveryone, VisibilityLink:
+		return true
+	}
+	return false
+}
+
+func isAdmin(user *User) bool {
+	return user.Role == "admin" || user.Role == "system"
+}
+
+// shareACL 一次请求中判断某个用户能否访问共享文件，规则一次性加载后在内存中匹配
+type shareACL struct {
+	user  *User
+	rules map[string]*ShareRule
+}
+
+func loadShareACL(user *User) *shareACL {
+	acl := &shareACL{user: user, rules: map[string]*ShareRule{}}
+	var rules []ShareRule
+	db.Find(&rules)
+	for i := range rules {
+		acl.rules[rules[i].Path] = &rules[i]
+	}
+	return acl
+}
+
+// 从路径本身开始逐级向上查找最近的规则
+func (a *shareACL) ruleFor(logicalPath string) *ShareRule {
+	for p := logicalPath; p != ""; {
+		if rule, ok := a.rules[p]; ok {
+			return rule
+		}
+		i := strings.LastIndex(p, "/")
+		if i < 0 {
+			break
+		}
+		p = p[:i]
+	}
+	return nil
+}
+
+// 判断能否查看和下载 owner 拥有的 logicalPath
+func (a *shareACL) canRead(logicalPath, owner string) bool {
+	if isAdmin(a.user) || owner == a.user.Username {
+		return true
+	}
+	rule := a.ruleFor(logicalPath)
+	if rule == nil {
+		return true
+	}
+	switch rule.Visibility {
+	case VisibilityEveryone:
+		return true
+	case VisibilityUsers:
+		return containsString(rule.Users, a.user.Username)
+	case VisibilityGroup:
+		return rule.Group != "" && rule.Group == a.user.ClassGroup
+	}
+	return false
+}
+
+// 路径实际生效的可见范围
+func (a *shareACL) visibility(logicalPath string) string {
+	if rule := a.ruleFor(logicalPath); rule != nil {
+		return rule.Visibility
+	}
+	return VisibilityEveryone
+}
+
+// 过滤出可以访问的文件
+func (a *shareACL) filterItems(items []SharedItem) []SharedItem {
+	result := items[:0]
+	for _, item := range items {
+		if a.canRead(item.Path, item.Owner) {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// 判断用户是否拥有路径下的全部内容（路径必须存在）
+func ownsSharedPath(username, logicalPath string) bool {
+	var total, owned int64
+	for _, model := range []interface{}{&SharedItem{}, &SharedFolder{}} {
+		var t, o int64
+		underSharedPath(db.Model(model), logicalPath).Count(&t)
+		underSharedPath(db.Model(model), logicalPath).Where("owner = ?", username).Count(&o)
+		total, owned = total+t, owned+o
+	}
+	return total > 0 && total == owned
+}
+
+// 路径下的内容全部删除后，清理其访问规则和分享链接
+func dropShareSettings(logicalPath string) {
+	if sharedPathExists(logicalPath) {
+		return
+	}
+	underSharedPath(db, logicalPath).Delete(&ShareRule{})
+	underSharedPath(db, logicalPath).Delete(&ShareLink{})
+}
+
+// 解析路径并检查当前用户是否可以管理它（所有者或管理员），失败时已写入响应
+func loadManagedSharedPath(c *gin.Context, rawPath string) (*User, string, bool) {
+	var user User
+	if err := db.Where("username = ?", c.MustGet("username").(string)).First(&user).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户不存在"})
+		return nil, "", false
+	}
+	logicalPath, err := resolveSharedPath(rawPath)
+	if err != nil || logicalPath == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "路径无效"})
+		return nil, "", false
+	}
+	if !sharedPathExists(logicalPath) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "文件(夹)不存在"})
+		return nil, "", false
+	}
+	if !isAdmin(&user) && !ownsSharedPath(user.Username, logicalPath) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "只能管理自己共享的文件"})
+		return nil, "", false
+	}
+	return &user, logicalPath, true
+}
+
+func registerShareRuleRoutes(r *gin.Engine, authMiddleware gin.HandlerFunc) {
+	// 查询访问规则，返回路径本身的规则和实际生效的规则（可能继承自上级目录）
+	r.GET("/api/files/access", authMiddleware, func(c *gin.Context) {
+		user, logicalPath, ok := loadManagedSharedPath(c, c.Query("path"))
+		if !ok {
+			return
+		}
+		acl := loadShareACL(user)
+		effective := acl.ruleFor(logicalPath)
+		if effective == nil {
+			effective = &ShareRule{Path: logicalPath, Visibility: VisibilityEveryone, Users: []string{}}
+		}
+		c.JSON(http.StatusOK, gin.H{"path": logicalPath, "rule": acl.rules[logicalPath], "effective": effective})
+	})
+
+	// 设置访问规则
+	r.POST("/api/files/access", authMiddleware, func(c *gin.Context) {
+		var req struct {
+			Path       string   `json:"path" binding:"required"`
+			Visibility string   `json:"visibility" binding:"required"`
+			Users      []string `json:"users"`
+			Group      string   `json:"group"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+			return
+		}
+		if !isValidVisibility(req.Visibility) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的可见范围"})
+			return
+		}
+		user, logicalPath, ok := loadManagedSharedPath(c, req.Path)
+		if !ok {
+			return
+		}
+
+		users := []string{}
+		if req.Visibility == VisibilityUsers {
+			for _, name := range req.Users {
+				name = strings.TrimSpace(name)
+				if name == "" || containsString(users, name) {
+					continue
+				}
+				var count int64
+				db.Model(&User{}).Where("username = ?", name).Count(&count)
+				if count == 0 {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "用户 " + name + " 不存在"})
+					return
+				}
+				users = append(users, name)
+			}
+			if len(users) == 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "请指定可以访问的用户"})
+				return
+			}
+		}
+		group := ""
+		if req.Visibility == VisibilityGroup {
+			group = strings.TrimSpace(req.Group)
+			if group == "" {
+				group = user.ClassGroup
+			}
+			if group == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "请指定班级/小组"})
+				return
+			}
+		}
+
+		var item SharedItem
+		underSharedPath(db, logicalPath).First(&item)
+		rule := ShareRule{Path: logicalPath, Owner: item.Owner, Visibility: req.Visibility, Users: users, Group: group}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("path = ?", logicalPath).Delete(&ShareRule{}).Error; err != nil {
+				return err
+			}
+			return tx.Create(&rule).Error
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "保存失败"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "访问权限已更新", "rule": rule})
+	})
+
+	// 删除路径本身的规则，恢复继承上级目录的设置
+	r.DELETE("/api/files/access", authMiddleware, func(c *gin.Context) {
+		_, logicalPath, ok := loadManagedSharedPath(c, c.Query("path"))
+		if !ok {
+			return
+		}
+		db.Where("path = ?", logicalPath).Delete(&ShareRule{})
+		c.JSON(http.StatusOK, gin.H{"message": "已恢复默认访问权限"})
+	})
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultAttachmentMaxSize = 20 << 20       // 单个聊天附件默认上限，可通过 Config 的 attachment_max_size 修改
+	attachmentUnsentLifetime = 24 * time.Hour // 上传后一直没有发出的附件保留时间
+)
+
+// attachmentInfo 随消息广播的附件信息
+type attachmentInfo struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Size         int64  `json:"size"`
+	MIME         string `json:"mime"`
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+}
+
+func (a *Attachment) info() *attachmentInfo {
+	info := &attachmentInfo{
+		ID:   a.ID,
+		Name: a.Name,
+		Size: a.Size,
+		MIME: a.MIME,
+		URL:  "/api/attachments/" + a.ID,
+	}
+	if a.Thumbnail {
+		info.ThumbnailURL = "/api/attachments/" + a.ID + "/thumbnail"
+	}
+	return info
+}
+
+// 删除附件记录，内容不再被引用时一并删除
+func removeAttachment(a *Attachment) {
+	db.Delete(a)
+	releaseBlobs(a.Checksum)
+}
+
+// 定期删除上传后没有发出的附件
+func cleanupAttachments() {
+	for {
+		var attachments []Attachment
+		db.Where("sent = ? AND created_at < ?", false, time.Now().Add(-attachmentUnsentLifetime)).Find(&attachments)
+		for i := range attachments {
+			log.Printf("清理未发送的聊天附件: %s (%s)", attachments[i].Name, attachments[i].Uploader)
+			removeAttachment(&attachments[i])
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+func registerAttachmentRoutes(r *gin.Engine, authMiddleware gin.HandlerFunc) {
+	go cleanupAttachments()
+
+	// 上传聊天附件，返回附件 ID，随后在消息中通过 attachment_id 引用
+	r.POST("/api/attachments", authMiddleware, func(c *gin.Context) {
+		username := c.MustGet("username").(string)
+		var user User
+		if err := db.Where("username = ?", username).First(&user).Error; err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "用户不存在"})
+			return
+		}
+		if !user.CanShareFiles && user.Role == "user" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "您已被禁止共享文件"})
+			return
+		}
+		if user.IsMuted {
+			c.JSON(http.StatusForbidden, gin.H{"error": "您已被禁言，无法发送附件"})
+			return
+		}
+
+		maxSize := getConfigInt("attachment_max_size", defaultAttachmentMaxSize)
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize+1<<20)
+		file, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无法获取文件"})
+			return
+		}
+		if file.Size > maxSize {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "附件不能超过" + formatBytes(maxSize) + "，较大的文件请通过文件共享上传"})
+			return
+		}
+		name, err := cleanPathSegment(filepath.Base(file.Filename))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "文件名无效: " + err.Error()})
+			return
+		}
+
+		// 聊天附件无法等待审核，需要审核的文件只能通过文件共享上传
+		decision := evaluateUploadPolicy(&user, []uploadEntry{{Name: name, Size: file.Size}})
+		if decision.Blocked {
+			c.JSON(http.StatusForbidden, gin.H{"error": decision.Reason})
+			return
+		}
+		if decision.NeedsReview {
+			c.JSON(http.StatusForbidden, gin.H{"error": "由于" + decision.Reason + "，该文件需要审核，请通过文件共享上传"})
+			return
+		}
+		if status, err := checkStorage(&user, file.Size); err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		stageDir, err := newStagingDir()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "保存文件失败"})
+			return
+		}
+		defer os.RemoveAll(stageDir)
+		stagePath := filepath.Join(stageDir, "upload")
+		if err := c.SaveUploadedFile(file, stagePath); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "保存文件失败"})
+			return
+		}
+		if q, err := scanUpload(stagePath, name, "", username); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		} else if q != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "文件未通过安全检查: " + q.Reason})
+			return
+		}
+		mimeType := detectMIME(stagePath, name)
+		hash, size, unlock, err := storeBlob(stagePath)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "保存文件失败"})
+			return
+		}
+
+		attachment := Attachment{
+			ID:       newUploadSessionID(),
+			Uploader: username,
+			Name:     name,
+			Size:     size,
+			MIME:     mimeType,
+			Checksum: hash,
+		}
+		if thumbnailTypes[mimeType] {
+			_, err := ensureThumbnail(hash, mimeType)
+			attachment.Thumbnail = err == nil
+		}
+		err = db.Create(&attachment).Error
+		unlock()
+		if err != nil {
+			releaseBlobs(hash)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "保存文件失败"})
+			return
+		}
+		c.JSON(http.StatusOK, attachment.info())
+	})
+
+	loadAttachment := func(c *gin.Context) (*Attachment, bool) {
+		var attachment Attachment
+		if err := db.Where("id = ?", c.Param("id")).First(&attachment).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "附件不存在"})
+			return nil, false
+		}
+		return &attachment, true
+	}
+
+	// 下载附件（聊天室对所有登录用户可见，附件同样如此），支持 Range
+	r.GET("/api/attachments/:id", authMiddleware, func(c *gin.Context) {
+		attachment, ok := loadAttachment(c)
+		if !ok {
+			return
+		}
+		f, err := os.Open(blobPath(attachment.Checksum))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "附件不存在"})
+			return
+		}
+		defer f.Close()
+		c.Header("ETag", `"`+attachment.Checksum+`"`)
+		c.Header("Content-Type", attachment.MIME)
+		c.Header("X-Content-Type-Options", "nosniff")
+		if isActiveContent(attachment.MIME) {
+			c.Header("Content-Disposition", "attachment")
+		}
+		http.ServeContent(c.Writer, c.Request, attachment.Name, attachment.CreatedAt, f)
+	})
+
+	// 附件缩略图
+	r.GET("/api/attachments/:id/thumbnail", authMiddleware, func(c *gin.Context) {
+		attachment, ok := loadAttachment(c)
+		if !ok {
+			return
+		}
+		path, err := ensureThumbnail(attachment.Checksum, attachment.MIME)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "该附件没有缩略图"})
+			return
+		}
+		c.Header("Cache-Control", "private, max-age=86400")
+		c.File(path)
+	})
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "image/gif"
+	_ "image/jpeg"
+)
+
+const (
+	avatarDir       = "./uploads"
+	avatarMaxBytes  = 5 << 20 // 上传头像最大 5MB
+	avatarMaxPixels = 4096    // 原图单边最大像素，防止解压炸弹
+	avatarSize      = 256     // 输出头像边长
+)
+
+// 允许上传的头像格式（按文件内容识别，而不是扩展名）
+var allowedAvatarTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+}
+
+// 校验并处理上传的头像，返回统一尺寸的正方形 PNG 数据
+func processAvatar(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, avatarMaxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("读取文件失败")
+	}
+	if len(data) > avatarMaxBytes {
+		return nil, fmt.Errorf("头像文件不能超过 %dMB", avatarMaxBytes>>20)
+	}
+
+	mimeType := http.DetectContentType(data)
+	if !allowedAvatarTypes[mimeType] {
+		return nil, fmt.Errorf("不支持的图片格式，仅支持 PNG/JPEG/GIF")
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("无法识别的图片文件")
+	}
+	if cfg.Width > avatarMaxPixels || cfg.Height > avatarMaxPixels {
+		return nil, fmt.Errorf("图片尺寸过大，单边不能超过 %d 像素", avatarMaxPixels)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("无法识别的图片文件")
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, squareThumbnail(img, avatarSize)); err != nil {
+		return nil, fmt.Errorf("图片处理失败")
+	}
+	return buf.Bytes(), nil
+}
+
+// 居中裁剪为正方形后缩放到 size x size
+func squareThumbnail(src image.Image, size int) *image.RGBA {
+	b := src.Bounds()
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+	x0 := b.Min.X + (b.Dx()-side)/2
+	y0 := b.Min.Y + (b.Dy()-side)/2
+
+	// 先转换成 RGBA，避免逐像素调用接口方法
+	crop := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(crop, crop.Bounds(), src, image.Pt(x0, y0), draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	if side >= size {
+		boxDownscale(crop, dst)
+		return dst
+	}
+	scale := float64(side) / float64(size)
+	for y := 0; y < size; y++ {
+		sy := (float64(y)+0.5)*scale - 0.5
+		for x := 0; x < size; x++ {
+			sx := (float64(x)+0.5)*scale - 0.5
+			dst.SetRGBA(x, y, bilinear(crop, sx, sy))
+		}
+	}
+	return dst
+}
+
+// 缩小时按区域取平均值，避免大图缩小后出现锯齿和摩尔纹
+func boxDownscale(src, dst *image.RGBA) {
+	srcSize, dstSize := src.Bounds().Dx(), dst.Bounds().Dx()
+	for y := 0; y < dstSize; y++ {
+		sy0, sy1 := y*srcSize/dstSize, (y+1)*srcSize/dstSize
+		for x := 0; x < dstSize; x++ {
+			sx0, sx1 := x*srcSize/dstSize, (x+1)*srcSize/dstSize
+			var r, g, b, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					c := src.RGBAAt(sx, sy)
+					r, g, b, a = r+uint64(c.R), g+uint64(c.G), b+uint64(c.B), a+uint64(c.A)
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{R: uint8(r / n), G: uint8(g / n), B: uint8(b / n), A: uint8(a / n)})
+		}
+	}
+}
+
+// 放大时使用双线性插值
+func bilinear(img *image.RGBA, x, y float64) color.RGBA {
+	maxIdx := img.Bounds().Dx() - 1
+	clamp := func(v int) int {
+		if v < 0 {
+			return 0
+		}
+		if v > maxIdx {
+			return maxIdx
+		}
+		return v
+	}
+	x0, y0 := int(x), int(y)
+	if x < 0 {
+		x0 = -1
+	}
+	if y < 0 {
+		y0 = -1
+	}
+	fx, fy := x-float64(x0), y-float64(y0)
+
+	c00 := img.RGBAAt(clamp(x0), clamp(y0))
+	c10 := img.RGBAAt(clamp(x0+1), clamp(y0))
+	c01 := img.RGBAAt(clamp(x0), clamp(y0+1))
+	c11 := img.RGBAAt(clamp(x0+1), clamp(y0+1))
+
+	mix := func(a, b, c, d uint8) uint8 {
+		top := float64(a)*(1-fx) + float64(b)*fx
+		bottom := float64(c)*(1-fx) + float64(d)*fx
+		return uint8(top*(1-fy) + bottom*fy + 0.5)
+	}
+	return color.RGBA{
+		R: mix(c00.R, c10.R, c01.R, c11.R),
+		G: mix(c00.G, c10.G, c01.G, c11.G),
+		B: mix(c00.B, c10.B, c01.B, c11.B),
+		A: mix(c00.A, c10.A, c01.A, c11.A),
+	}
+}
+
+// 根据用户名生成确定性的 5x5 对称像素头像（无需联网）
+func generateIdenticon(seed string) []byte {
+	sum := sha256.Sum256([]byte(seed))
+
+	const grid, cell, padding = 5, 40, 28
+	size := grid*cell + padding*2
+	fg := hslColor(float64(sum[29])/255*360, 0.55, 0.5)
+	bg := color.RGBA{R: 240, G: 240, B: 240, A: 255}
+
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(img, img.Bounds(), &image.Uniform{C: bg}, image.Point{}, draw.Src)
+	for row := 0; row < grid; row++ {
+		for col := 0; col < (grid+1)/2; col++ {
+			if sum[row*3+col]%2 == 0 {
+				continue
+			}
+			for _, c := range []int{col, grid - 1 - col} {
+				rect := image.Rect(padding+c*cell, padding+row*cell, padding+(c+1)*cell, padding+(row+1)*cell)
+				draw.Draw(img, rect, &image.Uniform{C: fg}, image.Point{}, draw.Src)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+func hslColor(h, s, l float64) color.RGBA {
+	hueToRGB := func(p, q, t float64) float64 {
+		if t < 0 {
+			t++
+		}
+		if t > 1 {
+			t--
+		}
+		switch {
+		case t < 1.0/6:
+			return p + (q-p)*6*t
+		case t < 1.0/2:
+			return q
+		case t < 2.0/3:
+			return p + (q-p)*(2.0/3-t)*6
+		}
+		return p
+	}
+	q := l + s - l*s
+	if l < 0.5 {
+		q = l * (1 + s)
+	}
+	p := 2*l - q
+	h /= 360
+	return color.RGBA{
+		R: uint8(hueToRGB(p, q, h+1.0/3) * 255),
+		G: uint8(hueToRGB(p, q, h) * 255),
+		B: uint8(hueToRGB(p, q, h-1.0/3) * 255),
+		A: 255,
+	}
+}
+
+// 保存头像数据到 uploads 目录，返回访问 URL
+func saveAvatarFile(username string, data []byte) (string, error) {
+	filename := fmt.Sprintf("%s_%d.png", username, time.Now().UnixNano())
+	if err := os.WriteFile(filepath.Join(avatarDir, filename), data, 0644); err != nil {
+		return "", err
+	}
+	return "/uploads/" + filename, nil
+}
+
+// 删除旧头像文件，只处理本服务存储在 uploads 下的文件
+func removeAvatarFile(avatarURL string) {
+	if !strings.HasPrefix(avatarURL, "/uploads/") {
+		return
+	}
+	name := filepath.Base(strings.TrimPrefix(avatarURL, "/uploads/"))
+	if name == "." || name == "/" {
+		return
+	}
+	os.Remove(filepath.Join(avatarDir, name))
+}
+
+// 为用户生成离线 identicon 头像并返回 URL
+func createDefaultAvatar(username string) (string, error) {
+	return saveAvatarFile(username, generateIdenticon(username))
+}
+
+// 将旧版本遗留的在线头像（dicebear）替换为本地 identicon
+func backfillOfflineAvatars() {
+	var users []User
+	db.Where("avatar = '' OR avatar IS NULL OR avatar LIKE ?", "http%://api.dicebear.com/%").Find(&users)
+	for _, u := range users {
+		avatarURL, err := createDefaultAvatar(u.Username)
+		if err != nil {
+			continue
+		}
+		db.Model(&User{}).Where("id = ?", u.ID).Update("avatar", avatarURL)
+	}
+}
@@ -0,0 +1,565 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	uploadSessionDir      = "./upload_sessions" // 分片上传过程中的临时文件目录
+	uploadChunkSize       = 8 << 20             // 建议的分片大小
+	uploadMaxChunkSize    = 32 << 20            // 单个分片最大大小
+	uploadSessionLifetime = 24 * time.Hour      // 超过该时间没有新分片的会话视为废弃
+)
+
+// 会话中第 index 个文件的分片暂存路径
+func uploadPartPath(sessionID string, index int) string {
+	return filepath.Join(uploadSessionDir, sessionID, fmt.Sprintf("%d.part", index))
+}
+
+// 已接收的字节数即暂存文件的大小
+func uploadReceived(sessionID string, index int) int64 {
+	info, err := os.Stat(uploadPartPath(sessionID, index))
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+func uploadProgress(session *UploadSession) []gin.H {
+	files := []gin.H{}
+	for i, f := range session.Files {
+		received := f.Size // 已导入的文件暂存文件已被移走
+		if !f.Done {
+			received = uploadReceived(session.ID, i)
+		}
+		files = append(files, gin.H{
+			"index":    i,
+			"path":     f.Path,
+			"size":     f.Size,
+			"received": received,
+			"done":     f.Done,
+		})
+	}
+	return files
+}
+
+// 会话中每个文件在目标目录下的相对路径。文件夹上传时路径第一段是顶层文件夹名，与 upload-folder 的 paths 约定一致
+func sessionTargets(sessionType string, files []UploadedFile) []string {
+	var targets []string
+	for _, f := range files {
+		if sessionType == "file" {
+			targets = append(targets, path.Base(f.Path))
+			continue
+		}
+		relPath := f.Path
+		if parts := strings.SplitN(f.Path, "/", 2); len(parts) == 2 {
+			relPath = parts[1]
+		}
+		targets = append(targets, relPath)
+	}
+	return targets
+}
+
+// 与普通上传接口相同的目录约定：单文件放到 <username>_uploads，文件夹对应 <username>_<文件夹名>
+func sessionLogicalDir(username, sessionType, folderName string) string {
+	if sessionType == "file" {
+		return username + "_uploads"
+	}
+	return fmt.Sprintf("%s_%s", username, folderName)
+}
+
+// 检查目标路径没有重复，也没有一个文件同时是另一个文件的上级目录
+func checkUniqueTargets(targets []string) error {
+	files := map[string]bool{}
+	dirs := map[string]bool{}
+	for _, target := range targets {
+		if files[target] {
+			return fmt.Errorf("文件 %s 重复", target)
+		}
+		files[target] = true
+		for dir := path.Dir(target); dir != "."; dir = path.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+	for target := range files {
+		if dirs[target] {
+			return fmt.Errorf("%s 既是文件又是文件夹", target)
+		}
+	}
+	return nil
+}
+
+// 移动文件，跨磁盘时回退为复制
+func moveFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func sessionUploadEntries(files []UploadedFile) []uploadEntry {
+	var entries []uploadEntry
+	for _, f := range files {
+		entries = append(entries, uploadEntry{Name: f.Path, Size: f.Size})
+	}
+	return entries
+}
+
+func newUploadSessionID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// 删除会话及其暂存文件
+func removeUploadSession(session *UploadSession) {
+	os.RemoveAll(filepath.Join(uploadSessionDir, session.ID))
+	db.Delete(session)
+}
+
+// 定期清理长时间没有活动的上传会话
+func cleanupUploadSessions() {
+	for {
+		var sessions []UploadSession
+		db.Where("updated_at < ?", time.Now().Add(-uploadSessionLifetime)).Find(&sessions)
+		for i := range sessions {
+			log.Printf("清理废弃的分片上传会话: %s (%s)", sessions[i].ID, sessions[i].Username)
+			removeUploadSession(&sessions[i])
+		}
+
+		// 没有对应会话记录的暂存目录也一并删除
+		entries, _ := os.ReadDir(uploadSessionDir)
+		for _, e := range entries {
+			var count int64
+			db.Model(&UploadSession{}).Where("id = ?", e.Name()).Count(&count)
+			if count == 0 {
+				os.RemoveAll(filepath.Join(uploadSessionDir, e.Name()))
+			}
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+// 分片上传路由: 初始化 -> 按偏移量 PUT 分片 -> 完成
+func registerChunkedUploadRoutes(r *gin.Engine, authMiddleware gin.HandlerFunc) {
+	os.MkdirAll(uploadSessionDir, os.ModePerm)
+	go cleanupUploadSessions()
+
+	// 读取会话并校验归属
+	loadSession := func(c *gin.Context) (*UploadSession, bool) {
+		var session UploadSession
+		if err := db.Where("id = ? AND username = ?", c.Param("id"), c.MustGet("username").(string)).First(&session).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "上传会话不存在或已过期"})
+			return nil, false
+		}
+		return &session, true
+	}
+
+	// 检查共享权限
+	checkShare := func(c *gin.Context) (*User, bool) {
+		var user User
+		if err := db.Where("username = ?", c.MustGet("username").(string)).First(&user).Error; err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "用户不存在"})
+			return nil, false
+		}
+		if !user.CanShareFiles && user.Role == "user" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "您已被禁止共享文件"})
+			return nil, false
+		}
+		return &user, true
+	}
+
+	// 初始化上传会话
+	r.POST("/api/chunked-uploads", authMiddleware, func(c *gin.Context) {
+		user, ok := checkShare(c)
+		if !ok {
+			return
+		}
+		var req struct {
+			Type       string         `json:"type" binding:"required"` // file, folder
+			FolderName string         `json:"folder_name"`
+			Files      []UploadedFile `json:"files" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || len(req.Files) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+			return
+		}
+		if req.Type != "file" && req.Type != "folder" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的上传类型"})
+			return
+		}
+		if req.Type == "file" && len(req.Files) != 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "单文件上传只能包含一个文件"})
+			return
+		}
+		if req.Type == "folder" {
+			name, err := cleanPathSegment(req.FolderName)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "文件夹名无效: " + err.Error()})
+				return
+			}
+			req.FolderName = name
+		}
+
+		var totalSize int64
+		for i, f := range req.Files {
+			p, err := cleanRelPath(f.Path)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "文件路径无效: " + err.Error()})
+				return
+			}
+			f.Path = p
+			f.SHA256 = strings.ToLower(f.SHA256)
+			if f.Path == "" || f.Size < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "文件路径或大小无效"})
+				return
+			}
+			req.Files[i] = f
+			totalSize += f.Size
+		}
+		if err := checkUniqueTargets(sessionTargets(req.Type, req.Files)); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "文件路径冲突: " + err.Error()})
+			return
+		}
+		if err := checkUploadTarget(user.Username, sessionLogicalDir(user.Username, req.Type, req.FolderName)); err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+
+		// 禁止的文件类型在上传前就拒绝，避免白白传输
+		if decision := evaluateUploadPolicy(user, sessionUploadEntries(req.Files)); decision.Blocked {
+			c.JSON(http.StatusForbidden, gin.H{"error": decision.Reason})
+			return
+		}
+
+		// 按声明的总大小预先检查配额，上传中的会话也会计入用量
+		if status, err := checkStorage(user, totalSize); err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		session := UploadSession{
+			ID:         newUploadSessionID(),
+			Username:   c.MustGet("username").(string),
+			Type:       req.Type,
+			FolderName: req.FolderName,
+			TotalSize:  totalSize,
+			Files:      req.Files,
+		}
+		if err := os.MkdirAll(filepath.Join(uploadSessionDir, session.ID), os.ModePerm); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "创建上传会话失败"})
+			return
+		}
+		// 预先创建暂存文件，空文件无需上传任何分片
+		for i := range session.Files {
+			if f, err := os.Create(uploadPartPath(session.ID, i)); err == nil {
+				f.Close()
+			}
+		}
+		if err := db.Create(&session).Error; err != nil {
+			os.RemoveAll(filepath.Join(uploadSessionDir, session.ID))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "创建上传会话失败"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"id":         session.ID,
+			"chunk_size": uploadChunkSize,
+			"files":      uploadProgress(&session),
+		})
+	})
+
+	// 查询上传进度（断线重连后据此从 received 处继续上传）
+	r.GET("/api/chunked-uploads/:id", authMiddleware, func(c *gin.Context) {
+		session, ok := loadSession(c)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"id":         session.ID,
+			"chunk_size": uploadChunkSize,
+			"files":      uploadProgress(session),
+		})
+	})
+
+	// 上传分片: PUT /api/chunked-uploads/:id/chunks/:index?offset=N，请求头 X-Chunk-SHA256 为分片的校验和
+	r.PUT("/api/chunked-uploads/:id/chunks/:index", authMiddleware, func(c *gin.Context) {
+		session, ok := loadSession(c)
+		if !ok {
+			return
+		}
+		index, err := strconv.Atoi(c.Param("index"))
+		if err != nil || index < 0 || index >= len(session.Files) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的文件序号"})
+			return
+		}
+		offset, err := strconv.ParseInt(c.Query("offset"), 10, 64)
+		if err != nil || offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的偏移量"})
+			return
+		}
+		expectedSum := strings.ToLower(c.GetHeader("X-Chunk-SHA256"))
+		if expectedSum == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "缺少分片校验和"})
+			return
+		}
+
+		data, err := io.ReadAll(io.LimitReader(c.Request.Body, uploadMaxChunkSize+1))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "读取分片失败"})
+			return
+		}
+		if len(data) > uploadMaxChunkSize {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "分片过大"})
+			return
+		}
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != expectedSum {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "分片校验失败，请重新上传该分片"})
+			return
+		}
+
+		file := session.Files[index]
+		if file.Done {
+			c.JSON(http.StatusConflict, gin.H{"error": "该文件已导入，无需再上传"})
+			return
+		}
+		received := uploadReceived(session.ID, index)
+		// 只允许顺序写入或重传已接收的部分，不能留下空洞
+		if offset > received {
+			c.JSON(http.StatusConflict, gin.H{"error": "分片偏移量不连续", "received": received})
+			return
+		}
+		if offset+int64(len(data)) > file.Size {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "分片超出文件声明的大小"})
+			return
+		}
+
+		f, err := os.OpenFile(uploadPartPath(session.ID, index), os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "保存分片失败"})
+			return
+		}
+		_, err = f.WriteAt(data, offset)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "保存分片失败"})
+			return
+		}
+		db.Model(session).Update("updated_at", time.Now())
+
+		c.JSON(http.StatusOK, gin.H{"received": uploadReceived(session.ID, index)})
+	})
+
+	// 完成上传：校验完整性后组装到 shared 或审核目录。中途失败时已处理的文件记录在会话中，
+	// 会话保留，可以再次调用完成接口继续
+	var completing sync.Map
+	r.POST("/api/chunked-uploads/:id/complete", authMiddleware, func(c *gin.Context) {
+		user, ok := checkShare(c)
+		if !ok {
+			return
+		}
+		session, ok := loadSession(c)
+		if !ok {
+			return
+		}
+		if _, busy := completing.LoadOrStore(session.ID, true); busy {
+			c.JSON(http.StatusConflict, gin.H{"error": "上传正在完成中，请稍候"})
+			return
+		}
+		defer completing.Delete(session.ID)
+		username := session.Username
+
+		for i, f := range session.Files {
+			if f.Done {
+				continue
+			}
+			if received := uploadReceived(session.ID, i); received != f.Size {
+				c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("文件 %s 尚未上传完成", f.Path), "files": uploadProgress(session)})
+				return
+			}
+			if f.SHA256 != "" {
+				sum, err := fileSHA256(uploadPartPath(session.ID, i))
+				if err != nil || sum != f.SHA256 {
+					// 整个文件校验失败，丢弃该文件已接收的数据以便重新上传
+					os.Remove(uploadPartPath(session.ID, i))
+					c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("文件 %s 校验失败，请重新上传", f.Path)})
+					return
+				}
+			}
+		}
+
+		// 策略可能在上传过程中被修改，以完成时的策略为准；重试完成时沿用第一次的判定，
+		// 避免一部分文件已直接导入、另一部分却进入审核
+		if session.Decision == nil {
+			decision := evaluateUploadPolicy(user, sessionUploadEntries(session.Files))
+			if decision.Blocked {
+				removeUploadSession(session)
+				c.JSON(http.StatusForbidden, gin.H{"error": decision.Reason})
+				return
+			}
+			session.Decision = &decision
+			if err := db.Save(session).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "保存文件失败"})
+				return
+			}
+		}
+		decision := *session.Decision
+		needsApproval := decision.NeedsReview
+
+		targets := sessionTargets(session.Type, session.Files)
+		logicalDir := sessionLogicalDir(username, session.Type, session.FolderName)
+		folderName := session.FolderName
+		if session.Type == "file" {
+			folderName = "uploads/" + targets[0]
+		}
+		// 上传期间可能注册了用户名与目标目录冲突的用户，需要审核的在审核通过时再检查
+		if !needsApproval {
+			if err := checkUploadTarget(username, logicalDir); err != nil {
+				c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		// 需要审核的先放到会话目录下，全部处理完后再整体移入审核目录，否则直接导入共享空间
+		reviewDir := filepath.Join(uploadSessionDir, session.ID, "review")
+		if needsApproval {
+			os.MkdirAll(reviewDir, os.ModePerm)
+		}
+		for i, target := range targets {
+			f := &session.Files[i]
+			if f.Done {
+				continue
+			}
+			// 安全检查，未通过的文件移入隔离区
+			q, err := scanUpload(uploadPartPath(session.ID, i), target, joinSharedPath(logicalDir, target), username)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("%s: %s", target, err.Error()), "files": uploadProgress(session)})
+				return
+			}
+			if q != nil {
+				f.Quarantined = true
+			} else {
+				if needsApproval {
+					var dst string
+					if dst, err = safeJoin(reviewDir, target); err == nil {
+						err = moveFile(uploadPartPath(session.ID, i), dst)
+					}
+				} else {
+					_, err = putSharedFile(uploadPartPath(session.ID, i), joinSharedPath(logicalDir, target), username)
+				}
+				if errors.Is(err, errNotOwner) {
+					c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("%s: %s", target, err.Error()), "files": uploadProgress(session)})
+					return
+				}
+				if err != nil {
+					log.Printf("完成分片上传失败 %s: %v", target, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "保存文件失败，请重新完成上传", "files": uploadProgress(session)})
+					return
+				}
+			}
+			f.Done = true
+			db.Save(session)
+		}
+
+		quarantined := []string{}
+		var quarantinedSize int64
+		for i, f := range session.Files {
+			if f.Quarantined {
+				quarantined = append(quarantined, targets[i])
+				quarantinedSize += f.Size
+			}
+		}
+		if len(quarantined) == len(targets) {
+			removeUploadSession(session)
+			c.JSON(http.StatusOK, gin.H{"message": "文件未通过安全检查，已被隔离等待管理员处理", "status": "quarantined", "quarantined": quarantined})
+			return
+		}
+		if needsApproval {
+			suffix := "file"
+			if session.Type == "folder" {
+				suffix = folderName
+			}
+			destDir := fmt.Sprintf("./temp_uploads/%d_%s_%s", time.Now().Unix(), username, suffix)
+			os.MkdirAll(tempUploadDir, os.ModePerm)
+			if err := os.Rename(reviewDir, destDir); err != nil {
+				log.Printf("移动待审核文件失败 %s: %v", destDir, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "保存文件失败，请重新完成上传", "files": uploadProgress(session)})
+				return
+			}
+			removeUploadSession(session)
+			db.Create(&PendingUpload{
+				Username:   username,
+				FolderName: folderName,
+				TotalSize:  session.TotalSize - quarantinedSize,
+				Status:     "pending",
+				TempPath:   destDir,
+				ReviewRule: decision.Rule,
+			})
+			c.JSON(http.StatusOK, gin.H{"message": "上传成功，由于" + decision.Reason + "，正在等待管理员审核" + quarantineNotice(quarantined), "status": "pending", "quarantined": quarantined})
+			return
+		}
+		removeUploadSession(session)
+		c.JSON(http.StatusOK, gin.H{"message": "上传成功" + quarantineNotice(quarantined), "status": "approved", "quarantined": quarantined})
+	})
+
+	// 取消上传
+	r.DELETE("/api/chunked-uploads/:id", authMiddleware, func(c *gin.Context) {
+		session, ok := loadSession(c)
+		if !ok {
+			return
+		}
+		removeUploadSession(session)
+		c.JSON(http.StatusOK, gin.H{"message": "已取消上传"})
+	})
+}
@@ -15,7 +15,8 @@ type Client struct {
 	hub        *Hub
 	conn       *websocket.Conn // websocket链接
 	send       chan Message    // 消息
-	Username   string          // 用户昵称
+	Username   string          // 登录用户名（来自 Token，不可由客户端修改）
+	Name       string          // 显示名
 	Avatar     string          // 头像
 	Role       string          // 角色: user, admin, system
 	Identifier string          // 唯一标识 (IP + Port)
@@ -38,11 +39,11 @@ func (c *Client) readPump() {
 		}
 
 		// 尝试解析为 JSON
+		// 客户端传来的 name/avatar 等身份字段一律忽略，发送者信息只以认证身份为准
 		var incoming struct {
-			Type    string `json:"type"`
-			Content string `json:"content"`
-			Avatar  string `json:"avatar"`
-			Name    string `json:"name"`
+			Type         string `json:"type"`
+			Content      string `json:"content"`
+			AttachmentID string `json:"attachment_id"` // 先通过 /api/attachments 上传得到的附件 ID
 		}
 
 		err = json.Unmarshal(payload, &incoming)
@@ -52,45 +53,63 @@ func (c *Client) readPump() {
 			incoming.Content = string(payload)
 		}
 
-		// 更新用户信息（如果前端传了）
-		if incoming.Name != "" {
-			c.Username = incoming.Name
-		}
-		if incoming.Avatar != "" {
-			c.Avatar = incoming.Avatar
-		}
-
 		// 查询数据库确认用户状态
 		var user User
-		if err := db.Where("username = ?", c.Username).First(&user).Error; err == nil {
-			if user.IsBanned {
-				c.sendSystemMsg("您的账号已被封禁")
-				c.conn.Close()
-				break
-			}
-			if user.IsMuted {
-				c.sendSystemMsg("您已被禁言，无法发送消息")
-				continue
-			}
+		if err := db.Where("username = ?", c.Username).First(&user).Error; err != nil {
+			c.sendSystemMsg("账号不存在")
+			c.conn.Close()
+			break
+		}
+		if user.IsBanned {
+			c.sendSystemMsg("您的账号已被封禁")
+			c.conn.Close()
+			break
 		}
+		if user.IsMuted {
+			c.sendSystemMsg("您已被禁言，无法发送消息")
+			continue
+		}
+
+		// 同步最新的显示名和头像
+		c.Name = user.Name()
+		c.Avatar = user.Avatar
 
 		// 处理指令
-		if strings.HasPrefix(incoming.Content, "/") {
+		if incoming.AttachmentID == "" && strings.HasPrefix(incoming.Content, "/") {
 			c.handleCommand(incoming.Content)
 			continue
 		}
 
+		// 只能发送自己上传的附件，并且发送时仍需有共享文件的权限
+		var attachment *attachmentInfo
+		if incoming.AttachmentID != "" {
+			var a Attachment
+			if err := db.Where("id = ? AND uploader = ?", incoming.AttachmentID, c.Username).First(&a).Error; err != nil {
+				c.sendSystemMsg("附件不存在或已过期")
+				continue
+			}
+			if !user.CanShareFiles && user.Role == "user" {
+				c.sendSystemMsg("您已被禁止共享文件")
+				continue
+			}
+			db.Model(&a).Update("sent", true)
+			attachment = a.info()
+		}
+
 		// 广播消息
 		msg := Message{
 			Sender:     c.Identifier,
-			SenderName: c.Username,
+			Username:   c.Username,
+			SenderName: c.Name,
 			Avatar:     c.Avatar,
 			Content:    incoming.Content,
 			Time:       time.Now().Format("15:04"),
 			Type:       "user",
 			Role:       c.Role,
+			Attachment: attachment,
 		}
 		c.hub.broadcast <- msg
+		db.Model(&User{}).Where("username = ?", c.Username).Update("message_count", gorm.Expr("message_count + 1"))
 	}
 }
 
@@ -0,0 +1,26 @@
+package main
+
+import "strconv"
+
+// 读取配置项，不存在时返回默认值
+func getConfigValue(key, def string) string {
+	var cfg Config
+	if err := db.Where("key = ?", key).First(&cfg).Error; err != nil {
+		return def
+	}
+	return cfg.Value
+}
+
+// 读取整数配置项，不存在或格式错误时返回默认值
+func getConfigInt(key string, def int64) int64 {
+	v, err := strconv.ParseInt(getConfigValue(key, ""), 10, 64)
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// 写入配置项
+func setConfigValue(key, value string) error {
+	return db.Save(&Config{Key: key, Value: value}).Error
+}
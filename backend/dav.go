@@ -0,0 +1,626 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/net/webdav"
+)
+
+// 通过 WebDAV 把共享空间挂载为网络磁盘。路径与网页中的逻辑路径一致（顶层目录为 <所有者>_<文件夹名>），
+// 读取遵循访问规则，写入与网页上传一样检查共享权限、所有权、配额、上传策略和安全检查
+const davPrefix = "/dav"
+
+const (
+	davAuthCacheTTL    = 5 * time.Minute // 账号密码验证结果的缓存时间，避免每个请求都计算 bcrypt
+	davAuthFailLimit   = 20              // 同一 IP 在时间窗口内允许的认证失败次数
+	davAuthFailWindow  = 10 * time.Minute
+	maxAppPasswordsPer = 20
+)
+
+// WebDAV 请求用到的方法，gin 的 Any 不包括扩展方法，需要逐个注册
+var davMethods = []string{
+	http.MethodOptions, http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete,
+	"PROPFIND", "PROPPATCH", "MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK",
+}
+
+var (
+	davLocks       = webdav.NewMemLS()
+	davFailLimiter = newIPRateLimiter(davAuthFailLimit, davAuthFailWindow)
+
+	davAuthMu    sync.Mutex
+	davAuthCache = map[string]time.Time{} // sha256(用户名 + 保存的密码哈希 + 密码) -> 过期时间
+)
+
+func hashAppPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+// 生成应用专用密码，分组显示便于手动输入
+func generateAppPassword() string {
+	buf := make([]byte, 15)
+	rand.Read(buf)
+	s := strings.ToLower(base32.StdEncoding.EncodeToString(buf))
+	return s[0:6] + "-" + s[6:12] + "-" + s[12:18] + "-" + s[18:24]
+}
+
+// 验证 WebDAV 客户端提供的用户名和密码，可以是账号密码或应用专用密码
+func checkDavCredentials(username, password string) bool {
+	var app AppPassword
+	if err := db.Where("username = ? AND hash = ?", username, hashAppPassword(password)).First(&app).Error; err == nil {
+		now := time.Now()
+		db.Model(&app).UpdateColumn("last_used_at", &now)
+		return true
+	}
+
+	var user User
+	if err := db.Where("username = ?", username).First(&user).Error; err != nil {
+		return false
+	}
+	// 缓存键包含数据库中保存的密码哈希，修改密码后旧密码的缓存立即失效
+	key := hashAppPassword(username + "\x00" + user.Password + "\x00" + password)
+	davAuthMu.Lock()
+	expires, ok := davAuthCache[key]
+	davAuthMu.Unlock()
+	if ok && time.Now().Before(expires) {
+		return true
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+		return false
+	}
+	davAuthMu.Lock()
+	for k, t := range davAuthCache {
+		if time.Now().After(t) {
+			delete(davAuthCache, k)
+		}
+	}
+	davAuthCache[key] = time.Now().Add(davAuthCacheTTL)
+	davAuthMu.Unlock()
+	return true
+}
+
+// davFileInfo 共享空间中的文件或目录
+type davFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	isDir   bool
+	item    *SharedItem // 文件对应的元数据，目录和正在写入的文件为 nil
+}
+
+func (fi *davFileInfo) Name() string       { return fi.name }
+func (fi *davFileInfo) Size() int64        { return fi.size }
+func (fi *davFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *davFileInfo) IsDir() bool        { return fi.isDir }
+func (fi *davFileInfo) Sys() interface{}   { return nil }
+
+func (fi *davFileInfo) Mode() fs.FileMode {
+	if fi.isDir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
+
+// 使用保存的类型，避免列目录时逐个读取文件内容判断
+func (fi *davFileInfo) ContentType(ctx context.Context) (string, error) {
+	if fi.item == nil {
+		return "", webdav.ErrNotImplemented
+	}
+	return fi.item.MIME, nil
+}
+
+// 与网页下载相同，内容不变 ETag 就不变
+func (fi *davFileInfo) ETag(ctx context.Context) (string, error) {
+	if fi.item == nil {
+		return "", webdav.ErrNotImplemented
+	}
+	return `"` + fi.item.Checksum + `"`, nil
+}
+
+func fileInfoOf(item *SharedItem) *davFileInfo {
+	return &davFileInfo{name: path.Base(item.Path), size: item.Size, modTime: item.UpdatedAt, item: item}
+}
+
+// davFS 以某个用户的身份访问共享空间，每个请求创建一个
+type davFS struct {
+	user *User
+	acl  *shareACL
+}
+
+// 把 WebDAV 路径转为逻辑路径
+func davPath(name string) (string, error) {
+	p, err := resolveSharedPath(strings.TrimPrefix(name, "/"))
+	if err != nil {
+		return "", os.ErrNotExist
+	}
+	return p, nil
+}
+
+// 判断能否在 logicalPath 写入：普通用户需要有共享权限，且只能写入自己的顶层目录
+func (d *davFS) canWrite(logicalPath string) bool {
+	if isAdmin(d.user) {
+		return true
+	}
+	if !d.user.CanShareFiles && d.user.Role == "user" {
+		return false
+	}
+	return inOwnShare(d.user.Username, logicalPath)
+}
+
+// 新内容的所有者：普通用户为自己，管理员写入时归顶层目录的所有者
+func (d *davFS) ownerFor(logicalPath string) string {
+	if isAdmin(d.user) {
+		return ownerOfTopLevel(sharedTopLevel(logicalPath))
+	}
+	return d.user.Username
+}
+
+// 检查上级目录：必须存在（顶层除外），且路径上不能有文件
+func checkDavParent(logicalPath string) error {
+	for p := sharedParent(logicalPath); p != ""; p = sharedParent(p) {
+		if _, err := findSharedFile(p); err == nil {
+			return os.ErrExist
+		}
+	}
+	if parent := sharedParent(logicalPath); parent != "" && !isSharedDir(parent) {
+		return os.ErrNotExist
+	}
+	return nil
+}
+
+func (d *davFS) stat(logicalPath string) (*davFileInfo, error) {
+	if logicalPath == "" {
+		return &davFileInfo{name: "/", isDir: true, modTime: time.Now()}, nil
+	}
+	if item, err := findSharedFile(logicalPath); err == nil {
+		if !d.acl.canRead(item.Path, item.Owner) {
+			return nil, os.ErrNotExist
+		}
+		return fileInfoOf(item), nil
+	}
+	if !isSharedDir(logicalPath) || !d.acl.canRead(logicalPath, ownerOfTopLevel(sharedTopLevel(logicalPath))) {
+		return nil, os.ErrNotExist
+	}
+	fi := &davFileInfo{name: path.Base(logicalPath), isDir: true}
+	var latest SharedItem
+	if err := db.Where("path >= ? AND path < ?", logicalPath+"/", logicalPath+"0").Order("updated_at desc").First(&latest).Error; err == nil {
+		fi.modTime = latest.UpdatedAt
+	} else {
+		var folder SharedFolder
+		db.Where("path = ?", logicalPath).First(&folder)
+		fi.modTime = folder.CreatedAt
+	}
+	return fi, nil
+}
+
+func (d *davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	p, err := davPath(name)
+	if err != nil {
+		return nil, err
+	}
+	return d.stat(p)
+}
+
+func (d *davFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	p, err := davPath(name)
+	if err != nil {
+		return err
+	}
+	if p == "" || !d.canWrite(p) {
+		return os.ErrPermission
+	}
+	if sharedPathExists(p) {
+		return os.ErrExist
+	}
+	if err := checkDavParent(p); err != nil {
+		return err
+	}
+	return db.Create(&SharedFolder{Owner: d.ownerFor(p), Path: p}).Error
+}
+
+func (d *davFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	p, err := davPath(name)
+	if err != nil {
+		return nil, err
+	}
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return d.create(p)
+	}
+	fi, err := d.stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if fi.isDir {
+		return &davDir{fs: d, path: p, info: fi}, nil
+	}
+	f, err := openSharedBlob(fi.item)
+	if err != nil {
+		return nil, err
+	}
+	return &davFile{File: f, info: fi}, nil
+}
+
+// 准备写入文件，内容先写到暂存目录，关闭时再按上传规则处理
+func (d *davFS) create(logicalPath string) (webdav.File, error) {
+	// 文件只能放在某个顶层目录中
+	if !strings.Contains(logicalPath, "/") || !d.canWrite(logicalPath) {
+		return nil, os.ErrPermission
+	}
+	if isSharedDir(logicalPath) {
+		return nil, os.ErrExist
+	}
+	if err := checkDavParent(logicalPath); err != nil {
+		return nil, err
+	}
+	if item, err := findSharedFile(logicalPath); err == nil && !isAdmin(d.user) && item.Owner != d.user.Username {
+		return nil, os.ErrPermission
+	}
+	if decision := evaluateUploadPolicy(d.user, []uploadEntry{{Name: path.Base(logicalPath)}}); decision.Blocked {
+		return nil, os.ErrPermission
+	}
+	stageDir, err := newStagingDir()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Create(filepath.Join(stageDir, "upload"))
+	if err != nil {
+		os.RemoveAll(stageDir)
+		return nil, err
+	}
+	return &davUpload{fs: d, path: logicalPath, stageDir: stageDir, f: f}, nil
+}
+
+func (d *davFS) RemoveAll(ctx context.Context, name string) error {
+	p, err := davPath(name)
+	if err != nil {
+		return err
+	}
+	if p == "" {
+		return os.ErrPermission
+	}
+	if !sharedPathExists(p) {
+		return os.ErrNotExist
+	}
+	owner := ""
+	if !isAdmin(d.user) {
+		if !ownsSharedPath(d.user.Username, p) {
+			return os.ErrPermission
+		}
+		owner = d.user.Username
+	}
+	_, err = trashSharedPath(p, owner, d.user.Username)
+	return err
+}
+
+func (d *davFS) Rename(ctx context.Context, oldName, newName string) error {
+	src, err := davPath(oldName)
+	if err != nil {
+		return err
+	}
+	dst, err := davPath(newName)
+	if err != nil {
+		return err
+	}
+	if src == "" || dst == "" {
+		return os.ErrPermission
+	}
+	if !sharedPathExists(src) {
+		return os.ErrNotExist
+	}
+	if !d.canWrite(dst) || (!isAdmin(d.user) && !ownsSharedPath(d.user.Username, src)) {
+		return os.ErrPermission
+	}
+	// 文件不能放在顶层
+	if _, err := findSharedFile(src); err == nil && !strings.Contains(dst, "/") {
+		return os.ErrPermission
+	}
+	if err := checkDavParent(dst); err != nil {
+		return err
+	}
+	// 与网页中的移动、重命名使用同样的上传策略检查；COPY 通过 OpenFile 写入，在 davUpload.Close 中检查
+	decision, err := checkTransferPolicy(d.user, src, dst, false)
+	if err != nil {
+		return err
+	}
+	if decision.NeedsReview && !isAdmin(d.user) {
+		return queueTransferReview(d.user, src, dst, decision, false)
+	}
+	// 目标已存在时 webdav 会按 Overwrite 头先删除，这里不会再有冲突
+	if _, err := transferSharedPath(src, dst, d.ownerFor(dst), "", false); err != nil {
+		var te *transferError
+		if errors.As(err, &te) && te.Status == http.StatusConflict {
+			return os.ErrExist
+		}
+		return err
+	}
+	return nil
+}
+
+// davFile 只读打开的共享文件
+type davFile struct {
+	*os.File
+	info *davFileInfo
+}
+
+func (f *davFile) Stat() (os.FileInfo, error) { return f.info, nil }
+
+func (f *davFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, os.ErrInvalid
+}
+
+func (f *davFile) Write(p []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+// davDir 打开的目录，列出当前用户可以访问的子项
+type davDir struct {
+	fs      *davFS
+	path    string
+	info    *davFileInfo
+	entries []os.FileInfo
+	loaded  bool
+}
+
+func (d *davDir) Close() error                                 { return nil }
+func (d *davDir) Read(p []byte) (int, error)                   { return 0, os.ErrInvalid }
+func (d *davDir) Seek(offset int64, whence int) (int64, error) { return 0, os.ErrInvalid }
+func (d *davDir) Write(p []byte) (int, error)                  { return 0, os.ErrPermission }
+func (d *davDir) Stat() (os.FileInfo, error)                   { return d.info, nil }
+
+func (d *davDir) Readdir(count int) ([]os.FileInfo, error) {
+	if !d.loaded {
+		d.loaded = true
+		for _, e := range listSharedDir(d.path) {
+			if !d.fs.acl.canRead(e.Path, e.Owner) {
+				continue
+			}
+			if e.IsDir {
+				d.entries = append(d.entries, &davFileInfo{name: e.Name, isDir: true, modTime: e.ModTime})
+			} else {
+				d.entries = append(d.entries, fileInfoOf(e.Item))
+			}
+		}
+	}
+	if count <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	n := min(count, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+// davUpload 正在写入的文件
+type davUpload struct {
+	fs       *davFS
+	path     string
+	stageDir string
+	f        *os.File
+	size     int64
+}
+
+func (u *davUpload) Read(p []byte) (int, error)                   { return 0, os.ErrInvalid }
+func (u *davUpload) Seek(offset int64, whence int) (int64, error) { return 0, os.ErrInvalid }
+func (u *davUpload) Readdir(count int) ([]os.FileInfo, error)     { return nil, os.ErrInvalid }
+
+func (u *davUpload) Write(p []byte) (int, error) {
+	n, err := u.f.Write(p)
+	u.size += int64(n)
+	return n, err
+}
+
+func (u *davUpload) Stat() (os.FileInfo, error) {
+	return &davFileInfo{name: path.Base(u.path), size: u.size, modTime: time.Now()}, nil
+}
+
+// 写入完成后按网页上传的规则处理：配额、上传策略、安全检查，需要审核时放入待审核
+func (u *davUpload) Close() error {
+	defer os.RemoveAll(u.stageDir)
+	if err := u.f.Close(); err != nil {
+		return err
+	}
+	user := u.fs.user
+	name := path.Base(u.path)
+	src := u.f.Name()
+
+	owner := u.fs.ownerFor(u.path)
+	ownerUser := user
+	if owner != user.Username {
+		var o User
+		if err := db.Where("username = ?", owner).First(&o).Error; err == nil {
+			ownerUser = &o
+		}
+	}
+	// 覆盖已有文件时只计算增加的部分
+	incoming := u.size
+	if existing, err := findSharedFile(u.path); err == nil && existing.Owner == owner {
+		incoming -= existing.Size
+	}
+	if _, err := checkStorage(ownerUser, max(incoming, 0)); err != nil {
+		return err
+	}
+	decision := evaluateUploadPolicy(user, []uploadEntry{{Name: name, Size: u.size}})
+	if decision.Blocked {
+		return errors.New(decision.Reason)
+	}
+	if q, err := scanUpload(src, name, u.path, user.Username); err != nil {
+		return err
+	} else if q != nil {
+		return fmt.Errorf("%w: %s", errQuarantined, q.Reason)
+	}
+
+	if !decision.NeedsReview {
+		_, err := putSharedFile(src, u.path, owner)
+		return err
+	}
+	// 与文件夹上传相同的待审核目录约定，通过后放回原位置
+	if !inOwnShare(user.Username, u.path) {
+		return errors.New("需要审核的文件只能上传到自己的文件夹")
+	}
+	top := sharedTopLevel(u.path)
+	folderName := strings.TrimPrefix(top, user.Username+"_")
+	tempDir := fmt.Sprintf("./temp_uploads/%d_%s_%s", time.Now().UnixNano(), user.Username, folderName)
+	os.MkdirAll(tempDir, os.ModePerm)
+	dst, err := safeJoin(tempDir, strings.TrimPrefix(u.path, top+"/"))
+	if err != nil {
+		os.RemoveAll(tempDir)
+		return err
+	}
+	os.MkdirAll(filepath.Dir(dst), os.ModePerm)
+	if err := moveFile(src, dst); err != nil {
+		os.RemoveAll(tempDir)
+		return err
+	}
+	log.Printf("WebDAV 上传 %s 需要审核: %s", u.path, decision.Reason)
+	return db.Create(&PendingUpload{
+		Username:   user.Username,
+		FolderName: folderName,
+		TotalSize:  u.size,
+		Status:     "pending",
+		TempPath:   tempDir,
+		ReviewRule: decision.Rule,
+	}).Error
+}
+
+// 统计响应的状态码和发送的字节数，用于记录下载
+type davResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *davResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *davResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func registerDavRoutes(r *gin.Engine, authMiddleware gin.HandlerFunc) {
+	handler := func(c *gin.Context) {
+		ip := getClientIP(c)
+		if isIPBanned(ip) {
+			c.String(http.StatusForbidden, "您的IP已被封禁")
+			return
+		}
+		if davFailLimiter.exceeded(ip) {
+			c.String(http.StatusTooManyRequests, "认证失败次数过多，请稍后再试")
+			return
+		}
+		username, password, ok := c.Request.BasicAuth()
+		if !ok || !checkDavCredentials(username, password) {
+			if ok {
+				davFailLimiter.allow(ip)
+			}
+			c.Header("WWW-Authenticate", `Basic realm="AirChat", charset="UTF-8"`)
+			c.String(http.StatusUnauthorized, "需要登录")
+			return
+		}
+		var user User
+		if err := db.Where("username = ?", username).First(&user).Error; err != nil {
+			c.String(http.StatusUnauthorized, "用户不存在")
+			return
+		}
+		if user.IsBanned || user.Status == "pending" {
+			c.String(http.StatusForbidden, "该账号无法使用")
+			return
+		}
+
+		w := &davResponseWriter{ResponseWriter: c.Writer}
+		h := &webdav.Handler{
+			Prefix:     davPrefix,
+			FileSystem: &davFS{user: &user, acl: loadShareACL(&user)},
+			LockSystem: davLocks,
+			Logger: func(r *http.Request, err error) {
+				if err != nil && !os.IsNotExist(err) {
+					log.Printf("WebDAV %s %s (%s): %v", r.Method, r.URL.Path, username, err)
+				}
+			},
+		}
+		h.ServeHTTP(w, c.Request)
+
+		// 完整下载文件时记录
+		if c.Request.Method == http.MethodGet && c.GetHeader("Range") == "" && w.status == http.StatusOK {
+			if p, err := davPath(strings.TrimPrefix(c.Request.URL.Path, davPrefix)); err == nil {
+				if item, err := findSharedFile(p); err == nil {
+					recordSharedDownload(item, username, "webdav", w.bytes)
+				}
+			}
+		}
+	}
+	for _, method := range davMethods {
+		r.Handle(method, davPrefix, handler)
+		r.Handle(method, davPrefix+"/*path", handler)
+	}
+
+	// 应用专用密码，用于 WebDAV 客户端登录，可以单独撤销而不必修改账号密码
+	r.GET("/api/app-passwords", authMiddleware, func(c *gin.Context) {
+		var passwords []AppPassword
+		db.Where("username = ?", c.MustGet("username").(string)).Order("created_at desc").Find(&passwords)
+		c.JSON(http.StatusOK, passwords)
+	})
+
+	r.POST("/api/app-passwords", authMiddleware, func(c *gin.Context) {
+		username := c.MustGet("username").(string)
+		var req struct {
+			Name string `json:"name" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "请填写名称"})
+			return
+		}
+		var count int64
+		db.Model(&AppPassword{}).Where("username = ?", username).Count(&count)
+		if count >= maxAppPasswordsPer {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("最多只能创建 %d 个应用专用密码", maxAppPasswordsPer)})
+			return
+		}
+		password := generateAppPassword()
+		app := AppPassword{Username: username, Name: strings.TrimSpace(req.Name), Hash: hashAppPassword(password)}
+		if err := db.Create(&app).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "创建失败"})
+			return
+		}
+		// 密码只在创建时返回一次
+		c.JSON(http.StatusOK, gin.H{"id": app.ID, "name": app.Name, "password": password, "created_at": app.CreatedAt})
+	})
+
+	r.DELETE("/api/app-passwords/:id", authMiddleware, func(c *gin.Context) {
+		result := db.Where("id = ? AND username = ?", c.Param("id"), c.MustGet("username").(string)).Delete(&AppPassword{})
+		if result.RowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "应用专用密码不存在"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "已撤销"})
+	})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+	"gorm.io/gorm"
+)
+
+// 显示名长度限制（按字符计）
+const (
+	displayNameMinLen = 1
+	displayNameMaxLen = 16
+)
+
+// 保留名称，任何用户都不能使用与之相似的显示名
+var reservedDisplayNames = []string{"system", "admin", "administrator", "root", "系统", "管理员", "系统管理员"}
+
+// 常见的跨文字形近字符，统一映射到拉丁字母后再比较
+var confusableRunes = map[rune]rune{
+	'а': 'a', 'е': 'e', 'о': 'o', 'р': 'p', 'с': 'c', 'х': 'x', 'у': 'y', 'к': 'k',
+	'м': 'm', 'т': 't', 'в': 'b', 'н': 'h', 'і': 'i', 'ј': 'j', 'ѕ': 's', 'ԁ': 'd',
+	'ԛ': 'q', 'ԝ': 'w', 'ӏ': 'l', 'ο': 'o', 'α': 'a', 'ν': 'v', 'ρ': 'p', 'τ': 't',
+	'κ': 'k', 'ι': 'i', 'υ': 'u', 'χ': 'x', 'β': 'b', 'ε': 'e', 'η': 'n', 'μ': 'u',
+	'0': 'o', '1': 'l', 'i': 'l', '|': 'l', '5': 's', '$': 's', '@': 'a',
+}
+
+// 计算显示名的"骨架"：NFKC 归一化、转小写、替换形近字符并去掉分隔符，
+// 骨架相同的两个名字在界面上很难区分，视为冲突
+func displayNameSkeleton(name string) string {
+	name = strings.ToLower(norm.NFKC.String(name))
+	var sb strings.Builder
+	for _, r := range name {
+		if unicode.IsSpace(r) || unicode.Is(unicode.Mn, r) || (unicode.IsPunct(r) && confusableRunes[r] == 0) {
+			continue
+		}
+		if mapped, ok := confusableRunes[r]; ok {
+			r = mapped
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// 校验显示名格式，返回去除首尾空白后的名字
+func normalizeDisplayName(name string) (string, error) {
+	name = strings.TrimSpace(norm.NFC.String(name))
+	if !utf8.ValidString(name) {
+		return "", fmt.Errorf("显示名包含非法字符")
+	}
+	length := utf8.RuneCountInString(name)
+	if length < displayNameMinLen || length > displayNameMaxLen {
+		return "", fmt.Errorf("显示名长度需在%d到%d个字符之间", displayNameMinLen, displayNameMaxLen)
+	}
+
+	prevSpace := false
+	marks := 0
+	for _, r := range name {
+		// 控制字符、零宽字符、双向控制符、全角空格等不可见字符一律拒绝
+		if !unicode.IsPrint(r) || unicode.Is(unicode.Cf, r) || unicode.Is(unicode.Co, r) {
+			return "", fmt.Errorf("显示名包含不可见或控制字符")
+		}
+		// 限制连续叠加的组合符号，防止刷屏式的"乱码"名字
+		if unicode.Is(unicode.Mn, r) {
+			marks++
+			if marks > 2 {
+				return "", fmt.Errorf("显示名包含过多组合符号")
+			}
+		} else {
+			marks = 0
+		}
+		if r == ' ' && prevSpace {
+			return "", fmt.Errorf("显示名不能包含连续空格")
+		}
+		prevSpace = r == ' '
+	}
+
+	skeleton := displayNameSkeleton(name)
+	if skeleton == "" {
+		return "", fmt.Errorf("显示名不能只包含符号")
+	}
+	for _, reserved := range reservedDisplayNames {
+		if skeleton == displayNameSkeleton(reserved) {
+			return "", fmt.Errorf("该显示名为系统保留名称")
+		}
+	}
+	return name, nil
+}
+
+// 检查显示名和写入显示名需要在同一把锁内完成，否则两个请求可能同时通过检查，写入相似的显示名。
+// 旧数据中可能已有骨架相同的用户（如 user1 和 userl），因此没有在 display_key 上加唯一索引
+var displayNameMu sync.Mutex
+
+// 检查显示名是否与其他用户的显示名或用户名相似，excludeUsername 为当前用户自己。
+// 调用方需持有 displayNameMu，并在同一个事务 tx 中写入
+func checkDisplayNameAvailable(tx *gorm.DB, name, excludeUsername string) error {
+	skeleton := displayNameSkeleton(name)
+
+	var count int64
+	tx.Unscoped().Model(&User{}).Where("display_key = ? AND username <> ?", skeleton, excludeUsername).Count(&count)
+	if count > 0 {
+		return fmt.Errorf("该显示名已被使用或与其他用户过于相似")
+	}
+
+	var usernames []string
+	tx.Unscoped().Model(&User{}).Where("username <> ?", excludeUsername).Pluck("username", &usernames)
+	for _, u := range usernames {
+		if displayNameSkeleton(u) == skeleton {
+			return fmt.Errorf("该显示名与其他用户的用户名过于相似")
+		}
+	}
+	return nil
+}
+
+// 为旧版本数据库中没有显示名的用户补全显示名
+func backfillDisplayNames() {
+	var users []User
+	db.Where("display_name = '' OR display_name IS NULL").Find(&users)
+	for _, u := range users {
+		db.Model(&User{}).Where("id = ?", u.ID).Updates(map[string]interface{}{
+			"display_name": u.Username,
+			"display_key":  displayNameSkeleton(u.Username),
+		})
+	}
+}
+
+// 获取用户用于展示的名字
+func (u *User) Name() string {
+	if u.DisplayName != "" {
+		return u.DisplayName
+	}
+	return u.Username
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 打包下载的默认大小上限（未压缩的文件总大小），可通过 Config 的 zip_max_size 修改，0 表示不限
+const defaultZipMaxSize = 4 << 30
+
+// 本身已经压缩过的格式，打包时直接存储，避免浪费 CPU 且几乎不会变小
+var storedExtensions = map[string]bool{
+	".zip": true, ".rar": true, ".7z": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true,
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".heic": true, ".avif": true,
+	".mp3": true, ".aac": true, ".m4a": true, ".ogg": true, ".opus": true, ".flac": true,
+	".mp4": true, ".mkv": true, ".mov": true, ".avi": true, ".webm": true, ".wmv": true, ".flv": true,
+	".docx": true, ".xlsx": true, ".pptx": true, ".odt": true, ".ods": true, ".odp": true, ".epub": true,
+	".jar": true, ".apk": true, ".pdf": true,
+}
+
+// 判断文件打包时是否直接存储而不压缩
+func zipStored(item *SharedItem) bool {
+	if storedExtensions[strings.ToLower(path.Ext(item.Path))] {
+		return true
+	}
+	mimeType, _, _ := strings.Cut(item.MIME, ";")
+	switch {
+	case strings.HasPrefix(mimeType, "video/"), strings.HasPrefix(mimeType, "audio/") && mimeType != "audio/wav" && mimeType != "audio/x-wav":
+		return true
+	case mimeType == "image/jpeg", mimeType == "image/png", mimeType == "image/gif", mimeType == "image/webp":
+		return true
+	case mimeType == "application/zip", mimeType == "application/x-gzip", mimeType == "application/gzip", mimeType == "application/x-rar-compressed", mimeType == "application/x-7z-compressed":
+		return true
+	}
+	return false
+}
+
+// 返回文件内容的 CRC-32，未计算过时读取一次并保存到所有相同内容的记录上
+func sharedCRC32(item *SharedItem) (uint32, error) {
+	if item.CRC32 != nil {
+		return *item.CRC32, nil
+	}
+	f, err := openSharedBlob(item)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	h := crc32.NewIEEE()
+	if _, err := io.Copy(h, f); err != nil {
+		return 0, err
+	}
+	sum := h.Sum32()
+	db.Model(&SharedItem{}).Where("checksum = ?", item.Checksum).Update("crc32", sum)
+	item.CRC32 = &sum
+	return sum, nil
+}
+
+// 返回单个共享文件的内容，支持 Range 断点续传，完整下载时计入下载次数。source 为下载记录中的来源
+func serveSharedFile(c *gin.Context, item *SharedItem, source string) {
+	f, err := openSharedBlob(item)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "文件不存在"})
+		return
+	}
+	defer f.Close()
+	if c.Request.Method == http.MethodGet && c.GetHeader("Range") == "" {
+		// 传输结束后按实际发送的字节数记录，304 等没有发送内容的不计
+		defer func() {
+			if c.Writer.Status() == http.StatusOK {
+				recordSharedDownload(item, c.GetString("username"), source, int64(c.Writer.Size()))
+			}
+		}()
+	}
+	// 内容不变 ETag 就不变，If-Range 续传时可以可靠地判断文件是否被替换
+	c.Header("ETag", `"`+item.Checksum+`"`)
+	c.Header("Content-Type", item.MIME)
+	c.Header("X-Content-Type-Options", "nosniff")
+	// 网页类文件在同源下直接打开可能执行脚本，一律作为附件下载
+	if isActiveContent(item.MIME) {
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(item.Path)))
+	}
+	http.ServeContent(c.Writer, c.Request, filepath.Base(item.Path), item.UpdatedAt, f)
+}
+
+func isActiveContent(mimeType string) bool {
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+	switch strings.TrimSpace(strings.ToLower(mimeType)) {
+	case "text/html", "application/xhtml+xml", "image/svg+xml", "text/xml", "application/xml", "application/javascript", "text/javascript":
+		return true
+	}
+	return false
+}
+
+// zip 中的一个文件
+type zipEntry struct {
+	Item *SharedItem
+	Name string // 在 zip 中的路径
+}
+
+// 按 MS-DOS 格式设置修改时间（CreateRaw 不会处理 Modified 字段）
+func setZipModTime(fh *zip.FileHeader, t time.Time) {
+	t = t.UTC()
+	if t.Year() < 1980 {
+		t = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	fh.ModifiedDate = uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	fh.ModifiedTime = uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+}
+
+// 统计写入字节数
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// 写出 zip。存储方式的文件预先写好大小和 CRC，头部长度完全确定；
+// dryRun 时不读取文件内容，只写入等长的占位数据，用于计算最终大小。写完的文件以 username、source 记录下载
+func writeSharedZip(w io.Writer, entries []zipEntry, dryRun bool, username, source string) error {
+	zw := zip.NewWriter(w)
+	buf := make([]byte, 32<<10)
+	for _, e := range entries {
+		item := e.Item
+		var flags uint16
+		if !isASCII(e.Name) && utf8.ValidString(e.Name) {
+			flags |= 0x800 // 文件名使用 UTF-8 编码
+		}
+
+		if !zipStored(item) {
+			if dryRun {
+				return fmt.Errorf("压缩后的大小无法预先计算")
+			}
+			fw, err := zw.CreateHeader(&zip.FileHeader{Name: e.Name, Method: zip.Deflate, Modified: item.UpdatedAt})
+			if err != nil {
+				return err
+			}
+			if err := copySharedBlob(fw, item, buf); err != nil {
+				return err
+			}
+			recordSharedDownload(item, username, source, item.Size)
+			continue
+		}
+
+		fh := &zip.FileHeader{
+			Name:               e.Name,
+			Method:             zip.Store,
+			Flags:              flags,
+			CompressedSize64:   uint64(item.Size),
+			UncompressedSize64: uint64(item.Size),
+		}
+		setZipModTime(fh, item.UpdatedAt)
+		if !dryRun {
+			sum, err := sharedCRC32(item)
+			if err != nil {
+				return err
+			}
+			fh.CRC32 = sum
+		}
+		fw, err := zw.CreateRaw(fh)
+		if err != nil {
+			return err
+		}
+		if dryRun {
+			for n := item.Size; n > 0; {
+				k := min(n, int64(len(buf)))
+				fw.Write(buf[:k])
+				n -= k
+			}
+			continue
+		}
+		if err := copySharedBlob(fw, item, buf); err != nil {
+			return err
+		}
+		recordSharedDownload(item, username, source, item.Size)
+	}
+	return zw.Close()
+}
+
+// 复制文件内容，长度必须与记录一致，否则预先计算的大小就不对了
+func copySharedBlob(w io.Writer, item *SharedItem, buf []byte) error {
+	f, err := openSharedBlob(item)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	n, err := io.CopyBuffer(w, io.LimitReader(f, item.Size), buf)
+	if err != nil {
+		return err
+	}
+	if n != item.Size {
+		return fmt.Errorf("文件 %s 内容不完整", item.Path)
+	}
+	return nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// 将一组共享文件打包为 zip 返回。全部文件都以存储方式打包时预先计算 Content-Length，浏览器可以显示进度
+func serveSharedZip(c *gin.Context, zipName string, entries []zipEntry, source string) {
+	var total int64
+	allStored := true
+	used := map[string]bool{}
+	for i := range entries {
+		item := entries[i].Item
+		total += item.Size
+		if !zipStored(item) {
+			allStored = false
+		}
+		// 批量下载时不同目录下可能有同名文件，重名时加序号
+		name := entries[i].Name
+		for n := 2; used[name]; n++ {
+			ext := path.Ext(entries[i].Name)
+			name = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(entries[i].Name, ext), n, ext)
+		}
+		used[name] = true
+		entries[i].Name = name
+
+		// 响应头发出后就无法再报告错误，先确认所有文件都存在
+		if info, err := os.Stat(blobPath(item.Checksum)); err != nil || info.Size() != item.Size {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "文件 " + item.Path + " 已损坏或丢失"})
+			return
+		}
+	}
+	if maxSize := getConfigInt("zip_max_size", defaultZipMaxSize); maxSize > 0 && total > maxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("打包下载的文件总大小 %s 超过上限 %s，请分批下载或单独下载大文件",
+			formatBytes(total), formatBytes(maxSize))})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.zip\"", zipName))
+	c.Header("Content-Type", "application/zip")
+	c.Header("X-Uncompressed-Size", strconv.FormatInt(total, 10))
+	if allStored {
+		var cw countingWriter
+		if err := writeSharedZip(&cw, entries, true, "", ""); err == nil {
+			c.Header("Content-Length", strconv.FormatInt(cw.n, 10))
+		}
+	}
+
+	if err := writeSharedZip(c.Writer, entries, false, c.GetString("username"), source); err != nil {
+		// 响应头已发出，只能中断传输；有 Content-Length 时客户端会发现下载不完整
+		log.Printf("Error writing zip: %v", err)
+	}
+}
@@ -0,0 +1,597 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 目标位置已有同名文件时的处理方式，为空时不做任何修改并返回冲突列表
+const (
+	ConflictOverwrite = "overwrite" // 覆盖同名文件
+	ConflictSkip      = "skip"      // 跳过同名文件
+	ConflictRename    = "rename"    // 目标已存在时自动改名为 “名称 (2).ext”
+)
+
+func isValidConflictMode(mode string) bool {
+	switch mode {
+	case "", ConflictOverwrite, ConflictSkip, ConflictRename:
+		return true
+	}
+	return false
+}
+
+// 逻辑路径的上级目录，顶层返回空
+func sharedParent(logicalPath string) string {
+	if i := strings.LastIndex(logicalPath, "/"); i >= 0 {
+		return logicalPath[:i]
+	}
+	return ""
+}
+
+// 顶层目录名（所有者_文件夹名）
+func sharedTopLevel(logicalPath string) string {
+	top, _, _ := strings.Cut(logicalPath, "/")
+	return top
+}
+
+// 判断逻辑路径是否位于用户自己的顶层目录下
+func inOwnShare(username, logicalPath string) bool {
+	top := sharedTopLevel(logicalPath)
+	return strings.HasPrefix(top, username+"_") && ownerOfTopLevel(top) == username
+}
+
+// 上传目标目录 <username>_<文件夹名> 可能正好是另一个用户的顶层目录（如 bob 上传 x_uploads 对应 bob_x 的 bob_x_uploads），
+// 上传前检查，避免覆盖别人的文件
+func checkUploadTarget(username, logicalDir string) error {
+	if !inOwnShare(username, logicalDir) {
+		return fmt.Errorf("文件夹 %s 与其他用户的共享文件夹冲突，请换一个名称", sharedTopLevel(logicalDir))
+	}
+	return nil
+}
+
+// 在 dir 下放置名为 name 的条目时的路径，放在顶层时加上所有者前缀
+func sharedTarget(dir, name, owner string) string {
+	if dir == "" {
+		return owner + "_" + name
+	}
+	return joinSharedPath(dir, name)
+}
+
+// 为已存在的路径生成一个未被占用的新名称，如 “报告 (2).docx”
+func uniqueSharedPath(logicalPath string, isFile bool) string {
+	if !sharedPathExists(logicalPath) {
+		return logicalPath
+	}
+	ext := ""
+	if isFile {
+		ext = path.Ext(logicalPath)
+	}
+	base := strings.TrimSuffix(logicalPath, ext)
+	for n := 2; ; n++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, n, ext)
+		if !sharedPathExists(candidate) {
+			return candidate
+		}
+	}
+}
+
+// 复制或移动的结果
+type transferResult struct {
+	Path      string   `json:"path"`                // 最终的目标路径（自动改名后可能与请求的不同）
+	Count     int      `json:"count"`               // 处理的文件数
+	Skipped   []string `json:"skipped,omitempty"`   // 因同名而跳过的文件
+	Conflicts []string `json:"conflicts,omitempty"` // 未指定处理方式时的冲突文件
+}
+
+// transferError 带 HTTP 状态码的错误
+type transferError struct {
+	Status int
+	Msg    string
+}
+
+func (e *transferError) Error() string { return e.Msg }
+
+// 将 src（文件或目录）复制或移动到 dst，目标中的文件归 owner 所有。
+// 移动时访问规则、分享链接随之更新；被覆盖文件的内容在不再被引用时删除
+func transferSharedPath(src, dst, owner, conflict string, copyOnly bool) (*transferResult, error) {
+	if err := checkTransferPaths(src, dst); err != nil {
+		return nil, err
+	}
+	items := sharedFilesUnder(src)
+	var folders []SharedFolder
+	underSharedPath(db, src).Find(&folders)
+	if len(items) == 0 && len(folders) == 0 {
+		return nil, &transferError{http.StatusNotFound, "文件(夹)不存在"}
+	}
+	srcIsFile := len(items) == 1 && items[0].Path == src
+
+	if conflict == ConflictRename {
+		dst = uniqueSharedPath(dst, srcIsFile)
+	}
+	target := func(p string) string {
+		return dst + strings.TrimPrefix(p, src)
+	}
+
+	// 检查冲突：同名文件按 conflict 处理；文件与目录互相占位时无法覆盖，只能改名
+	fileAt := map[string]bool{}
+	isFileAt := func(p string) bool {
+		ok, checked := fileAt[p]
+		if !checked {
+			_, err := findSharedFile(p)
+			ok = err == nil
+			fileAt[p] = ok
+		}
+		return ok
+	}
+	for p := sharedParent(dst); p != ""; p = sharedParent(p) {
+		if isFileAt(p) {
+			return nil, &transferError{http.StatusConflict, "目标位置 " + p + " 是一个文件"}
+		}
+	}
+	result := &transferResult{Path: dst}
+	conflicts := map[string]bool{}
+	for _, item := range items {
+		newPath := target(item.Path)
+		for p := sharedParent(newPath); p != sharedParent(dst) && p != ""; p = sharedParent(p) {
+			if isFileAt(p) {
+				return nil, &transferError{http.StatusConflict, "目标位置 " + p + " 是一个文件，无法放入目录"}
+			}
+		}
+		if isSharedDir(newPath) {
+			return nil, &transferError{http.StatusConflict, "目标位置 " + newPath + " 是一个目录，无法用文件覆盖"}
+		}
+		if isFileAt(newPath) {
+			conflicts[newPath] = true
+			result.Conflicts = append(result.Conflicts, newPath)
+		}
+	}
+	for _, folder := range folders {
+		if newPath := target(folder.Path); isFileAt(newPath) {
+			return nil, &transferError{http.StatusConflict, "目标位置 " + newPath + " 是一个文件，无法放入目录"}
+		}
+	}
+	if len(result.Conflicts) > 0 && conflict == "" {
+		return result, &transferError{http.StatusConflict, "目标位置已有同名文件，请选择覆盖、跳过或自动改名"}
+	}
+	result.Conflicts = nil
+
+	var released, overwritten []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, item := range items {
+			newPath := target(item.Path)
+			if conflicts[newPath] {
+				if conflict == ConflictSkip {
+					result.Skipped = append(result.Skipped, newPath)
+					continue
+				}
+				var old SharedItem
+				if err := tx.Where("path = ?", newPath).First(&old).Error; err != nil {
+					return err
+				}
+				// 与上传覆盖一样，被覆盖的内容保存为历史版本
+				if err := saveFileVersion(tx, &old); err != nil {
+					return err
+				}
+				if err := tx.Delete(&old).Error; err != nil {
+					return err
+				}
+				released = append(released, old.Checksum)
+				overwritten = append(overwritten, old.Owner)
+			}
+			if copyOnly {
+				copied := SharedItem{
+					Owner:       owner,
+					Path:        newPath,
+					Checksum:    item.Checksum,
+					Size:        item.Size,
+					MIME:        item.MIME,
+					Description: item.Description,
+					CRC32:       item.CRC32,
+				}
+				if err := tx.Create(&copied).Error; err != nil {
+					return err
+				}
+			} else if err := tx.Model(&SharedItem{}).Where("id = ?", item.ID).
+				Updates(map[string]interface{}{"path": newPath, "owner": owner}).Error; err != nil {
+				return err
+			}
+			result.Count++
+		}
+
+		for _, folder := range folders {
+			newPath := target(folder.Path)
+			var count int64
+			tx.Model(&SharedFolder{}).Where("path = ?", newPath).Count(&count)
+			if count > 0 {
+				// 目标目录已存在，合并即可
+				if !copyOnly {
+					if err := tx.Delete(&folder).Error; err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if copyOnly {
+				if err := tx.Create(&SharedFolder{Owner: owner, Path: newPath}).Error; err != nil {
+					return err
+				}
+			} else if err := tx.Model(&folder).Updates(map[string]interface{}{"path": newPath, "owner": owner}).Error; err != nil {
+				return err
+			}
+		}
+		if copyOnly {
+			return nil
+		}
+
+		// 历史版本跟随文件移动（跳过的文件除外）
+		var versions []FileVersion
+		underSharedPath(tx, src).Where("trash_id = 0").Find(&versions)
+		for _, v := range versions {
+			if containsString(result.Skipped, target(v.Path)) {
+				continue
+			}
+			if err := tx.Model(&v).Updates(map[string]interface{}{"path": target(v.Path), "owner": owner}).Error; err != nil {
+				return err
+			}
+		}
+		if len(result.Skipped) > 0 {
+			return nil
+		}
+
+		// 整体移动后，原路径上的访问规则和分享链接跟随到新位置
+		var rules []ShareRule
+		underSharedPath(tx, src).Find(&rules)
+		for _, rule := range rules {
+			newPath := target(rule.Path)
+			if err := tx.Where("path = ?", newPath).Delete(&ShareRule{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&rule).Updates(map[string]interface{}{"path": newPath, "owner": owner}).Error; err != nil {
+				return err
+			}
+		}
+		var links []ShareLink
+		underSharedPath(tx, src).Find(&links)
+		for _, link := range links {
+			if err := tx.Model(&link).Update("path", target(link.Path)).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	releaseBlobs(released...)
+	pruneOwnersVersions(overwritten)
+	if !copyOnly {
+		dropShareSettings(src)
+	}
+	return result, nil
+}
+
+// 检查复制或移动的目标位置是否有效
+func checkTransferPaths(src, dst string) error {
+	if src == dst {
+		return &transferError{http.StatusBadRequest, "目标位置与原位置相同"}
+	}
+	if strings.HasPrefix(dst, src+"/") {
+		return &transferError{http.StatusBadRequest, "不能移动或复制到自身的子目录中"}
+	}
+	return nil
+}
+
+// 按上传的规则检查复制、移动或重命名到 dst 后的文件：禁止的类型直接拒绝，需要审核时在返回的判定中标出。
+// 移动和重命名不增加内容，不按大小审核；扩展名改变的文件还要按新名称重新经过安全检查
+func checkTransferPolicy(user *User, src, dst string, copyOnly bool) (uploadDecision, error) {
+	if err := checkTransferPaths(src, dst); err != nil {
+		return uploadDecision{}, err
+	}
+	items := sharedFilesUnder(src)
+	entries := make([]uploadEntry, 0, len(items))
+	for _, item := range items {
+		entry := uploadEntry{Name: dst + strings.TrimPrefix(item.Path, src)}
+		if copyOnly {
+			entry.Size = item.Size
+		}
+		entries = append(entries, entry)
+	}
+	decision := evaluateUploadPolicy(user, entries)
+	if decision.Blocked {
+		return decision, &transferError{http.StatusForbidden, decision.Reason}
+	}
+	for i, item := range items {
+		name := entries[i].Name
+		if strings.EqualFold(path.Ext(item.Path), path.Ext(name)) {
+			continue
+		}
+		if reason := rescanRenamedFile(blobPath(item.Checksum), name); reason != "" {
+			return decision, &transferError{http.StatusForbidden, path.Base(name) + " 未通过安全检查: " + reason}
+		}
+	}
+	return decision, nil
+}
+
+// 需要审核的复制、移动或重命名：与 WebDAV 上传一样，把目标位置的内容放入待审核，通过后放到 dst；
+// 移动和重命名时原位置的内容移入回收站
+func queueTransferReview(user *User, src, dst string, decision uploadDecision, copyOnly bool) error {
+	if !inOwnShare(user.Username, dst) {
+		return &transferError{http.StatusForbidden, "需要审核的文件只能放到自己的文件夹"}
+	}
+	if sharedPathExists(dst) {
+		return &transferError{http.StatusConflict, "目标位置已有同名文件或文件夹"}
+	}
+	top := sharedTopLevel(dst)
+	folderName := strings.TrimPrefix(top, user.Username+"_")
+	tempDir := fmt.Sprintf("./temp_uploads/%d_%s_%s", time.Now().UnixNano(), user.Username, folderName)
+	os.MkdirAll(tempDir, os.ModePerm)
+	var total int64
+	for _, item := range sharedFilesUnder(src) {
+		target, err := safeJoin(tempDir, strings.TrimPrefix(dst+strings.TrimPrefix(item.Path, src), top+"/"))
+		if err == nil {
+			err = copySharedBlobTo(&item, target)
+		}
+		if err != nil {
+			os.RemoveAll(tempDir)
+			return err
+		}
+		total += item.Size
+	}
+	err := db.Create(&PendingUpload{
+		Username:   user.Username,
+		FolderName: folderName,
+		TotalSize:  total,
+		Status:     "pending",
+		TempPath:   tempDir,
+		ReviewRule: decision.Rule,
+	}).Error
+	if err != nil {
+		os.RemoveAll(tempDir)
+		return err
+	}
+	log.Printf("%s 将 %s 放到 %s 需要审核: %s", user.Username, src, dst, decision.Reason)
+	if copyOnly {
+		return nil
+	}
+	_, err = trashSharedPath(src, user.Username, user.Username)
+	return err
+}
+
+// 把共享文件的内容复制到本地路径
+func copySharedBlobTo(item *SharedItem, target string) error {
+	in, err := openSharedBlob(item)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return err
+	}
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// 文件操作路由：用户只能操作自己共享的内容，管理员版本挂在 /api/admin/files 下，可以操作任何位置
+func registerFileOpRoutes(r *gin.Engine, authMiddleware gin.HandlerFunc, adminGroup *gin.RouterGroup) {
+	for _, asAdmin := range []bool{false, true} {
+		group := r.Group("/api/files", authMiddleware)
+		if asAdmin {
+			group = adminGroup.Group("/files")
+		}
+
+		// 加载当前用户，普通用户需要有共享权限
+		loadOperator := func(c *gin.Context) (*User, bool) {
+			var user User
+			if err := db.Where("username = ?", c.MustGet("username").(string)).First(&user).Error; err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "用户不存在"})
+				return nil, false
+			}
+			if !asAdmin && !user.CanShareFiles && user.Role == "user" {
+				c.JSON(http.StatusForbidden, gin.H{"error": "您已被禁止共享文件"})
+				return nil, false
+			}
+			return &user, true
+		}
+
+		// 解析源路径，普通用户只能操作自己拥有的内容
+		loadSource := func(c *gin.Context, user *User, rawPath string) (string, bool) {
+			src, err := resolveSharedPath(rawPath)
+			if err != nil || src == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "路径无效"})
+				return "", false
+			}
+			if !sharedPathExists(src) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "文件(夹)不存在"})
+				return "", false
+			}
+			if !asAdmin && !ownsSharedPath(user.Username, src) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "只能管理自己共享的文件"})
+				return "", false
+			}
+			return src, true
+		}
+
+		// 目标路径的所有者，普通用户只能放到自己的顶层目录下
+		targetOwner := func(c *gin.Context, user *User, dst string) (string, bool) {
+			if !asAdmin {
+				if !inOwnShare(user.Username, dst) {
+					c.JSON(http.StatusForbidden, gin.H{"error": "只能放到自己共享的文件夹中"})
+					return "", false
+				}
+				return user.Username, true
+			}
+			return ownerOfTopLevel(sharedTopLevel(dst)), true
+		}
+
+		transfer := func(c *gin.Context, user *User, src, dst, conflict string, copyOnly bool) {
+			owner, ok := targetOwner(c, user, dst)
+			if !ok {
+				return
+			}
+			if copyOnly {
+				var total int64
+				for _, item := range sharedFilesUnder(src) {
+					total += item.Size
+				}
+				var ownerUser User
+				if err := db.Where("username = ?", owner).First(&ownerUser).Error; err == nil {
+					if status, err := checkStorage(&ownerUser, total); err != nil {
+						c.JSON(status, gin.H{"error": err.Error()})
+						return
+					}
+				}
+			}
+			decision, err := checkTransferPolicy(user, src, dst, copyOnly)
+			// 管理员本身负责审核，不再提交给自己
+			if err == nil && decision.NeedsReview && !isAdmin(user) {
+				if err = queueTransferReview(user, src, dst, decision, copyOnly); err == nil {
+					c.JSON(http.StatusOK, gin.H{"message": "由于" + decision.Reason + "，正在等待管理员审核，通过后放到新位置", "status": "pending", "path": dst})
+					return
+				}
+			}
+			var result *transferResult
+			if err == nil {
+				result, err = transferSharedPath(src, dst, owner, conflict, copyOnly)
+			}
+			if err != nil {
+				if te, ok := err.(*transferError); ok {
+					resp := gin.H{"error": te.Msg}
+					if result != nil {
+						resp["conflicts"] = result.Conflicts
+					}
+					c.JSON(te.Status, resp)
+					return
+				}
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "操作失败"})
+				return
+			}
+			c.JSON(http.StatusOK, result)
+		}
+
+		// 新建目录，放在顶层时自动加上所有者前缀
+		group.POST("/mkdir", func(c *gin.Context) {
+			var req struct {
+				Parent string `json:"parent"`
+				Name   string `json:"name" binding:"required"`
+				Owner  string `json:"owner"` // 仅管理员在顶层新建时可指定所有者
+			}
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+				return
+			}
+			user, ok := loadOperator(c)
+			if !ok {
+				return
+			}
+			parent, err := resolveSharedPath(req.Parent)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "路径无效"})
+				return
+			}
+			name, err := cleanPathSegment(req.Name)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "文件夹名无效: " + err.Error()})
+				return
+			}
+			owner := user.Username
+			if asAdmin && parent == "" && req.Owner != "" {
+				owner = req.Owner
+			} else if parent != "" {
+				owner = ownerOfTopLevel(sharedTopLevel(parent))
+			}
+			dst := sharedTarget(parent, name, owner)
+			if _, ok := targetOwner(c, user, dst); !ok {
+				return
+			}
+			for p := parent; p != ""; p = sharedParent(p) {
+				if _, err := findSharedFile(p); err == nil {
+					c.JSON(http.StatusConflict, gin.H{"error": "目标位置 " + p + " 是一个文件"})
+					return
+				}
+			}
+			if sharedPathExists(dst) {
+				c.JSON(http.StatusConflict, gin.H{"error": "同名文件或文件夹已存在"})
+				return
+			}
+			if err := db.Create(&SharedFolder{Owner: owner, Path: dst}).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "创建失败"})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"message": "创建成功", "path": dst})
+		})
+
+		// 重命名，顶层目录的新名称不含所有者前缀
+		group.POST("/rename", func(c *gin.Context) {
+			var req struct {
+				Path     string `json:"path" binding:"required"`
+				Name     string `json:"name" binding:"required"`
+				Conflict string `json:"conflict"`
+			}
+			if err := c.ShouldBindJSON(&req); err != nil || !isValidConflictMode(req.Conflict) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+				return
+			}
+			user, ok := loadOperator(c)
+			if !ok {
+				return
+			}
+			src, ok := loadSource(c, user, req.Path)
+			if !ok {
+				return
+			}
+			name, err := cleanPathSegment(req.Name)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "名称无效: " + err.Error()})
+				return
+			}
+			transfer(c, user, src, sharedTarget(sharedParent(src), name, ownerOfTopLevel(sharedTopLevel(src))), req.Conflict, false)
+		})
+
+		// 移动或复制到目标目录下
+		for _, op := range []string{"move", "copy"} {
+			copyOnly := op == "copy"
+			group.POST("/"+op, func(c *gin.Context) {
+				var req struct {
+					Path     string `json:"path" binding:"required"`
+					To       string `json:"to" binding:"required"` // 目标目录
+					Conflict string `json:"conflict"`
+				}
+				if err := c.ShouldBindJSON(&req); err != nil || !isValidConflictMode(req.Conflict) {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+					return
+				}
+				user, ok := loadOperator(c)
+				if !ok {
+					return
+				}
+				src, ok := loadSource(c, user, req.Path)
+				if !ok {
+					return
+				}
+				dir, err := resolveSharedPath(req.To)
+				if err != nil || dir == "" {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "目标路径无效"})
+					return
+				}
+				transfer(c, user, src, joinSharedPath(dir, path.Base(src)), req.Conflict, copyOnly)
+			})
+		}
+	}
+}
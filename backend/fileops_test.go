@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 建立 alice 拥有的 alice_课件/说明.txt，返回文件操作路由
+func setupRenameTest(t *testing.T, policy UploadPolicy) *gin.Engine {
+	t.Helper()
+	setupTestStorage(t)
+	db.Create(&User{Username: "alice", Role: "user", CanShareFiles: true, Status: "active"})
+	if err := setUploadPolicy(policy); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile("src.txt", []byte("hello"), 0644)
+	if _, err := putSharedFile("src.txt", "alice_课件/说明.txt", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	auth := func(c *gin.Context) { c.Set("username", "alice") }
+	registerFileOpRoutes(r, auth, r.Group("/api/admin", auth))
+	return r
+}
+
+func postJSON(r *gin.Engine, url, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, url, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+// 不能通过重命名绕过禁止的扩展名
+func TestRenameChecksBlockedExtension(t *testing.T) {
+	policy := defaultUploadPolicy
+	policy.BlockedExtensions = []string{".exe"}
+	r := setupRenameTest(t, policy)
+
+	rec := postJSON(r, "/api/files/rename", `{"path":"alice_课件/说明.txt","name":"说明.exe"}`)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if _, err := findSharedFile("alice_课件/说明.txt"); err != nil {
+		t.Fatal("被拒绝后原文件应保持不变")
+	}
+}
+
+// 重命名为需要审核的类型时，新名称的文件进入待审核，原文件移入回收站
+func TestRenameToReviewExtensionQueuesReview(t *testing.T) {
+	policy := defaultUploadPolicy
+	policy.ReviewExtensions = []string{".csv"}
+	r := setupRenameTest(t, policy)
+
+	rec := postJSON(r, "/api/files/rename", `{"path":"alice_课件/说明.txt","name":"说明.csv"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if sharedPathExists("alice_课件/说明.txt") || sharedPathExists("alice_课件/说明.csv") {
+		t.Fatal("审核通过前新旧名称都不应出现在共享空间中")
+	}
+	var pending PendingUpload
+	if err := db.First(&pending).Error; err != nil {
+		t.Fatal(err)
+	}
+	files := listPendingFiles(&pending)
+	if pending.FolderName != "课件" || len(files) != 1 || files[0].Path != "说明.csv" {
+		t.Fatalf("pending = %+v, files = %+v", pending, files)
+	}
+	var trashed int64
+	db.Model(&TrashEntry{}).Count(&trashed)
+	if trashed != 1 {
+		t.Fatalf("trashed = %d", trashed)
+	}
+}
+
+// 扩展名改变后按新名称重新检查内容，内容与新类型不符时拒绝
+func TestRenameRescansChangedExtension(t *testing.T) {
+	r := setupRenameTest(t, defaultUploadPolicy)
+
+	rec := postJSON(r, "/api/files/rename", `{"path":"alice_课件/说明.txt","name":"说明.pdf"}`)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+}
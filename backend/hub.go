@@ -9,6 +9,9 @@ type Hub struct {
 	broadcast  chan Message // 广播通道
 	register   chan *Client // 新用户登记通道
 	unregister chan *Client // 用户注销通道
+
+	blockMu sync.RWMutex
+	blocks  map[string]map[string]bool // 屏蔽关系缓存: blocker -> blocked 集合
 }
 
 // 3. init Hub
@@ -18,7 +21,52 @@ func newHub() *Hub {
 		broadcast:  make(chan Message),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+		blocks:     make(map[string]map[string]bool),
+	}
+}
+
+// 从数据库加载屏蔽关系
+func (h *Hub) loadBlocks() {
+	var blocks []Block
+	db.Find(&blocks)
+	h.blockMu.Lock()
+	defer h.blockMu.Unlock()
+	h.blocks = make(map[string]map[string]bool)
+	for _, b := range blocks {
+		if h.blocks[b.Blocker] == nil {
+			h.blocks[b.Blocker] = make(map[string]bool)
+		}
+		h.blocks[b.Blocker][b.Blocked] = true
+	}
+}
+
+// 更新屏蔽关系缓存
+func (h *Hub) setBlocked(blocker, blocked string, on bool) {
+	h.blockMu.Lock()
+	defer h.blockMu.Unlock()
+	if on {
+		if h.blocks[blocker] == nil {
+			h.blocks[blocker] = make(map[string]bool)
+		}
+		h.blocks[blocker][blocked] = true
+		return
 	}
+	delete(h.blocks[blocker], blocked)
+}
+
+// 判断 receiver 是否屏蔽了 sender
+func (h *Hub) isBlocked(receiver, sender string) bool {
+	h.blockMu.RLock()
+	defer h.blockMu.RUnlock()
+	return h.blocks[receiver][sender]
+}
+
+// 判断消息是否应投递给该客户端，系统消息（没有发送者用户名）总是投递
+func (h *Hub) shouldDeliver(client *Client, message Message) bool {
+	if message.Username == "" {
+		return true
+	}
+	return !h.isBlocked(client.Username, message.Username)
 }
 
 func (h *Hub) run() {
@@ -38,6 +86,9 @@ func (h *Hub) run() {
 		case message := <-h.broadcast:
 			h.mu.RLock()
 			for client := range h.clients {
+				if !h.shouldDeliver(client, message) {
+					continue
+				}
 				select {
 				case client.send <- message:
 				default:
@@ -49,6 +100,20 @@ func (h *Hub) run() {
 	}
 }
 
+// 向某个用户的所有在线连接发送消息
+func (h *Hub) sendToUser(username string, message Message) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for client := range h.clients {
+		if client.Username == username {
+			select {
+			case client.send <- message:
+			default:
+			}
+		}
+	}
+}
+
 // 根据用户名断开在线用户连接
 func (h *Hub) disconnectByUsername(username string) {
 	var targets []*Client
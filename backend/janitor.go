@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	tempUploadDir            = "./temp_uploads"
+	defaultPendingUploadDays = 14 // 待审核上传超过该天数未处理时过期，可通过 Config 的 pending_upload_expire_days 修改，0 表示不过期
+	janitorGracePeriod       = time.Hour
+	janitorInterval          = time.Hour
+)
+
+// janitorReport 一次清理的结果
+type janitorReport struct {
+	ExpiredUploads  int `json:"expired_uploads"`
+	RemovedTempDirs int `json:"removed_temp_dirs"`
+	RemovedAvatars  int `json:"removed_avatars"`
+}
+
+var janitorMu sync.Mutex
+
+// 清理长期未审核的上传、没有记录的临时目录和不再使用的头像文件。
+// 刚写入的文件可能还没有对应的数据库记录（如上传仍在保存中），修改时间在 janitorGracePeriod 内的不处理
+func runJanitor(hub *Hub) janitorReport {
+	janitorMu.Lock()
+	defer janitorMu.Unlock()
+	var report janitorReport
+
+	// 过期的待审核上传：删除临时文件，状态记为 expired 并通知上传者
+	if days := getConfigInt("pending_upload_expire_days", defaultPendingUploadDays); days > 0 {
+		var expired []PendingUpload
+		db.Where("status = ? AND created_at < ?", "pending", time.Now().AddDate(0, 0, -int(days))).Find(&expired)
+		for i := range expired {
+			pending := &expired[i]
+			// 可能正在被审核，加锁后重新确认状态
+			unlock := lockPendingUpload(pending.ID)
+			if db.First(pending, pending.ID).Error != nil || pending.Status != "pending" {
+				unlock()
+				continue
+			}
+			log.Printf("待审核上传已过期: %s (%s)", pending.FolderName, pending.Username)
+			os.RemoveAll(pending.TempPath)
+			pending.Status = "expired"
+			pending.TotalSize = 0
+			db.Save(pending)
+			unlock()
+			notifyUser(hub, pending.Username, "upload_expired",
+				fmt.Sprintf("您上传的「%s」超过 %d 天未被审核，已过期删除，如有需要请重新上传", pendingDisplayName(pending), days))
+			report.ExpiredUploads++
+		}
+	}
+
+	// 没有待审核记录的临时目录
+	var pendingUploads []PendingUpload
+	db.Select("temp_path").Where("status = ?", "pending").Find(&pendingUploads)
+	inUse := map[string]bool{}
+	for _, p := range pendingUploads {
+		inUse[filepath.Clean(p.TempPath)] = true
+	}
+	entries, _ := os.ReadDir(tempUploadDir)
+	for _, e := range entries {
+		dir := filepath.Join(tempUploadDir, e.Name())
+		if inUse[dir] || recentlyModified(e) {
+			continue
+		}
+		log.Printf("删除没有审核记录的临时目录: %s", dir)
+		os.RemoveAll(dir)
+		report.RemovedTempDirs++
+	}
+
+	// 没有用户使用的头像文件
+	var users []User
+	db.Select("avatar").Where("avatar LIKE ?", "/uploads/%").Find(&users)
+	avatars := map[string]bool{}
+	for _, u := range users {
+		avatars[filepath.Base(strings.TrimPrefix(u.Avatar, "/uploads/"))] = true
+	}
+	entries, _ = os.ReadDir(avatarDir)
+	for _, e := range entries {
+		if !e.Type().IsRegular() || avatars[e.Name()] || recentlyModified(e) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(avatarDir, e.Name())); err == nil {
+			report.RemovedAvatars++
+		}
+	}
+	if report.RemovedAvatars > 0 {
+		log.Printf("删除了 %d 个不再使用的头像文件", report.RemovedAvatars)
+	}
+	return report
+}
+
+func recentlyModified(e os.DirEntry) bool {
+	info, err := e.Info()
+	return err != nil || time.Since(info.ModTime()) < janitorGracePeriod
+}
+
+func registerJanitorRoutes(hub *Hub, adminGroup *gin.RouterGroup) {
+	go func() {
+		for {
+			runJanitor(hub)
+			time.Sleep(janitorInterval)
+		}
+	}()
+
+	// 待审核上传的过期天数
+	adminGroup.GET("/janitor_settings", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"pending_expire_days": getConfigInt("pending_upload_expire_days", defaultPendingUploadDays)})
+	})
+
+	adminGroup.POST("/janitor_settings", func(c *gin.Context) {
+		var req struct {
+			PendingExpireDays *int64 `json:"pending_expire_days" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || *req.PendingExpireDays < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+			return
+		}
+		if err := setConfigValue("pending_upload_expire_days", strconv.FormatInt(*req.PendingExpireDays, 10)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "保存失败"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "设置已保存"})
+	})
+
+	// 立即执行一次清理
+	adminGroup.POST("/janitor/run", func(c *gin.Context) {
+		c.JSON(http.StatusOK, runJanitor(hub))
+	})
+}
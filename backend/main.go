@@ -1,20 +1,23 @@
 package main
 
 import (
-	"archive/zip"
 	"bytes"
 	"embed"
+	"errors"
 	"fmt"
-	"io"
 	"io/fs"
 	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -111,7 +114,8 @@ func main() {
 	}
 
 	// 自动迁移
-	db.AutoMigrate(&User{}, &Message{}, &IPBan{}, &Config{}, &PendingUpload{})
+	db.AutoMigrate(&User{}, &Message{}, &IPBan{}, &Config{}, &PendingUpload{}, &InviteCode{}, &Block{}, &UploadSession{}, &SharedItem{}, &SharedFolder{}, &FileVersion{}, &SearchContent{}, &ShareRule{}, &ShareLink{}, &Attachment{}, &TrashEntry{}, &TrashedBlob{}, &Notification{}, &QuarantineItem{}, &DownloadRecord{}, &AppPassword{})
+	backfillDisplayNames()
 
 	// 初始化默认管理员和系统管理员密码
 	var adminConfig Config
@@ -129,27 +133,47 @@ func main() {
 	// 添加 CORS 中间件
 	config := cors.DefaultConfig()
 	config.AllowAllOrigins = true
-	config.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization"}
+	config.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization", "X-Chunk-SHA256"}
 	r.Use(cors.New(config))
 
 	hub := newHub()
+	hub.loadBlocks()
 	go hub.run()
 
 	// 注册接口
 	r.POST("/api/register", func(c *gin.Context) {
-		if isIPBanned(getClientIP(c)) {
+		clientIP := getClientIP(c)
+		if isIPBanned(clientIP) {
 			c.JSON(http.StatusForbidden, gin.H{"error": "您的IP已被封禁"})
 			return
 		}
 
+		mode := getRegistrationMode()
+		if mode == RegistrationClosed {
+			c.JSON(http.StatusForbidden, gin.H{"error": "当前已关闭注册"})
+			return
+		}
+
+		if !registerLimiter.allow(clientIP) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "注册过于频繁，请稍后再试"})
+			return
+		}
+
 		var req struct {
-			Username string `json:"username" binding:"required"`
-			Password string `json:"password" binding:"required"`
+			Username    string `json:"username" binding:"required"`
+			Password    string `json:"password" binding:"required"`
+			DisplayName string `json:"display_name"` // 可选，留空则使用用户名
+			InviteCode  string `json:"invite_code"`
 		}
 		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
 			return
 		}
+		req.InviteCode = strings.TrimSpace(req.InviteCode)
+		if mode == RegistrationInvite && req.InviteCode == "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "当前仅允许使用邀请码注册"})
+			return
+		}
 
 		// 验证用户名规则：字母/数字/下划线，不超过12位
 		match, _ := regexp.MatchString("^[a-zA-Z0-9_]{1,12}$", req.Username)
@@ -165,24 +189,77 @@ func main() {
 			return
 		}
 
+		// 未填写显示名时使用用户名，与旧数据补全的规则一致，不做保留名检查，
+		// 否则 admin、root 等以前可以注册的用户名会被拒绝；相似度在写入的事务中检查
+		displayName := req.Username
+		if req.DisplayName != "" {
+			var err error
+			if displayName, err = normalizeDisplayName(req.DisplayName); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
 		// 密码加密
 		hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 
+		// 生成离线可用的默认头像，失败时留空由前端显示占位图
+		avatarURL, err := createDefaultAvatar(req.Username)
+		if err != nil {
+			log.Printf("生成默认头像失败: %v", err)
+		}
+
 		user := User{
 			Username:      req.Username,
+			DisplayName:   displayName,
+			DisplayKey:    displayNameSkeleton(displayName),
 			Password:      string(hashedPassword),
-			Avatar:        "https://api.dicebear.com/7.x/bottts/svg?seed=" + req.Username,
+			Avatar:        avatarURL,
 			Role:          "user",
 			CanPlayGames:  true,
 			CanShareFiles: true,
+			Status:        "active",
+			RegisterIP:    clientIP,
+		}
+		if mode == RegistrationApproval {
+			user.Status = "pending"
 		}
 
-		if err := db.Create(&user).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "注册失败"})
+		displayNameMu.Lock()
+		err = db.Transaction(func(tx *gorm.DB) error {
+			// 不能与其他用户的显示名或用户名相似，防止仿冒
+			if err := checkDisplayNameAvailable(tx, displayName, req.Username); err != nil {
+				return err
+			}
+			// 邀请码在任何模式下都会被记录，仅邀请模式下强制要求
+			if req.InviteCode != "" {
+				if err := useInviteCode(tx, req.InviteCode); err != nil {
+					return err
+				}
+				user.InviteCode = req.InviteCode
+			}
+			if err := tx.Create(&user).Error; err != nil {
+				return fmt.Errorf("注册失败")
+			}
+			return nil
+		})
+		displayNameMu.Unlock()
+		if err != nil {
+			removeAvatarFile(avatarURL)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "注册成功"})
+		if user.Status == "pending" {
+			c.JSON(http.StatusOK, gin.H{"message": "注册成功，请等待管理员审核", "status": "pending"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "注册成功", "status": "active"})
+	})
+
+	// 查询当前注册模式（注册页据此决定是否显示邀请码输入框）
+	r.GET("/api/registration-mode", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"mode": getRegistrationMode()})
 	})
 
 	// 登录接口
@@ -217,6 +294,11 @@ func main() {
 			return
 		}
 
+		if user.Status == "pending" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "该账号正在等待管理员审核"})
+			return
+		}
+
 		// 生成 JWT
 		expirationTime := time.Now().Add(24 * time.Hour)
 		claims := &Claims{
@@ -235,6 +317,7 @@ func main() {
 		c.JSON(http.StatusOK, gin.H{
 			"token":           tokenString,
 			"username":        user.Username,
+			"display_name":    user.Name(),
 			"avatar":          user.Avatar,
 			"role":            user.Role,
 			"can_play_games":  user.CanPlayGames,
@@ -279,6 +362,11 @@ func main() {
 			return
 		}
 
+		if user.Status == "pending" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "该账号正在等待管理员审核"})
+			return
+		}
+
 		// http -> WebSocket
 		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 		if err != nil {
@@ -291,6 +379,7 @@ func main() {
 			conn:       conn,
 			send:       make(chan Message, 256),
 			Username:   user.Username,
+			Name:       user.Name(),
 			Avatar:     user.Avatar,
 			Role:       user.Role,
 			Identifier: conn.RemoteAddr().String(),
@@ -304,8 +393,23 @@ func main() {
 	})
 
 	// JWT 中间件
+	// 只有浏览器直接打开的下载链接和 <img> 引用的图片允许通过 URL 参数传递 Token，
+	// 其他接口必须使用请求头，避免 Token 出现在普通请求的地址和访问日志中
+	queryTokenRoutes := map[string]bool{
+		"/shared/*filepath":              true,
+		"/api/preview":                   true, // 缩略图
+		"/api/download-folder":           true,
+		"/api/batch-download":            true,
+		"/api/files/versions/:id":        true,
+		"/api/attachments/:id":           true,
+		"/api/attachments/:id/thumbnail": true,
+	}
 	authMiddleware := func(c *gin.Context) {
 		tokenString := c.GetHeader("Authorization")
+		if tokenString == "" && c.Request.Method == http.MethodGet && queryTokenRoutes[c.FullPath()] {
+			// 浏览器直接打开下载链接时无法附带请求头，与 WebSocket 一样允许通过 URL 参数传递
+			tokenString = c.Query("token")
+		}
 		if tokenString == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "未提供 Token"})
 			c.Abort()
@@ -333,31 +437,54 @@ func main() {
 	}
 
 	// 头像上传接口
-	os.MkdirAll("./uploads", os.ModePerm)
-	r.Static("/uploads", "./uploads")
+	os.MkdirAll(avatarDir, os.ModePerm)
+	backfillOfflineAvatars()
+	r.Static("/uploads", avatarDir)
 
 	r.POST("/api/upload-avatar", authMiddleware, func(c *gin.Context) {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, avatarMaxBytes+1<<20)
 		file, err := c.FormFile("avatar")
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "无法获取文件"})
 			return
 		}
+		if file.Size > avatarMaxBytes {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("头像文件不能超过 %dMB", avatarMaxBytes>>20)})
+			return
+		}
 
 		username := c.MustGet("username").(string)
-		filename := fmt.Sprintf("%d_%s", time.Now().Unix(), file.Filename)
-		filepath := "./uploads/" + filename
+		var user User
+		if err := db.Where("username = ?", username).First(&user).Error; err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "用户不存在"})
+			return
+		}
 
-		if err := c.SaveUploadedFile(file, filepath); err != nil {
+		src, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无法读取文件"})
+			return
+		}
+		data, err := processAvatar(src)
+		src.Close()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		avatarURL, err := saveAvatarFile(username, data)
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "保存文件失败"})
 			return
 		}
 
 		// 更新数据库中的用户头像
-		avatarURL := "/uploads/" + filename
 		if err := db.Model(&User{}).Where("username = ?", username).Update("avatar", avatarURL).Error; err != nil {
+			removeAvatarFile(avatarURL)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "更新数据库失败"})
 			return
 		}
+		removeAvatarFile(user.Avatar)
 
 		c.JSON(http.StatusOK, gin.H{
 			"message": "上传成功",
@@ -365,95 +492,278 @@ func main() {
 		})
 	})
 
-	// ====== 文件共享路由 ======
-	os.MkdirAll("./shared", os.ModePerm)
-	r.Static("/shared", "./shared")
-	os.MkdirAll("./temp_uploads", os.ModePerm) // 150MB超限审核存储目录
+	// 修改显示名
+	r.POST("/api/me/display-name", authMiddleware, func(c *gin.Context) {
+		username := c.MustGet("username").(string)
+		var req struct {
+			DisplayName string `json:"display_name" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+			return
+		}
 
-	// 上传文件夹（需登录）
-	r.POST("/api/upload-folder", authMiddleware, func(c *gin.Context) {
+		displayName, err := normalizeDisplayName(req.DisplayName)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		displayNameMu.Lock()
+		var taken bool
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := checkDisplayNameAvailable(tx, displayName, username); err != nil {
+				taken = true
+				return err
+			}
+			return tx.Model(&User{}).Where("username = ?", username).Updates(map[string]interface{}{
+				"display_name": displayName,
+				"display_key":  displayNameSkeleton(displayName),
+			}).Error
+		})
+		displayNameMu.Unlock()
+		if taken {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失败"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "显示名修改成功", "display_name": displayName})
+	})
+
+	// 修改个人资料（只更新请求中出现的字段）
+	r.POST("/api/me/profile", authMiddleware, func(c *gin.Context) {
 		username := c.MustGet("username").(string)
+		var req struct {
+			Bio         *string `json:"bio"`
+			Pronouns    *string `json:"pronouns"`
+			ClassGroup  *string `json:"class_group"`
+			Contact     *string `json:"contact"`
+			HideFromDir *bool   `json:"hide_from_directory"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+			return
+		}
+		// 班级/小组决定能否查看按小组共享的文件，只能由管理员设置
+		if req.ClassGroup != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "班级/小组由管理员设置"})
+			return
+		}
 
-		// 检查权限
-		var user User
-		if err := db.Where("username = ?", username).First(&user).Error; err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "用户不存在"})
+		updateData := map[string]interface{}{}
+		fields := map[string]*string{
+			"bio":      req.Bio,
+			"pronouns": req.Pronouns,
+			"contact":  req.Contact,
+		}
+		for field, value := range fields {
+			if value == nil {
+				continue
+			}
+			normalized, err := normalizeProfileField(field, *value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			updateData[field] = normalized
+		}
+		if req.HideFromDir != nil {
+			updateData["hide_from_dir"] = *req.HideFromDir
+		}
+		if len(updateData) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "没有需要修改的内容"})
 			return
 		}
-		if !user.CanShareFiles && user.Role == "user" {
-			c.JSON(http.StatusForbidden, gin.H{"error": "您已被禁止共享文件"})
+
+		if err := db.Model(&User{}).Where("username = ?", username).Updates(updateData).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失败"})
 			return
 		}
+		c.JSON(http.StatusOK, gin.H{"message": "个人资料已更新"})
+	})
 
-		folderName := c.PostForm("folderName")
-		if folderName == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "文件夹名不能为空"})
+	// 查看用户公开资料
+	r.GET("/api/users/:username", authMiddleware, func(c *gin.Context) {
+		var user User
+		if err := db.Where("username = ? AND status = ?", c.Param("username"), "active").First(&user).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
 			return
 		}
-		folderName = strings.ReplaceAll(folderName, "..", "")
-		folderName = strings.Trim(folderName, "/\\")
+		c.JSON(http.StatusOK, publicProfile(&user))
+	})
 
-		form, err := c.MultipartForm()
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "无法解析表单"})
+	// 成员目录（支持按用户名、显示名、班级搜索）
+	r.GET("/api/users", authMiddleware, func(c *gin.Context) {
+		query := db.Where("status = ? AND hide_from_dir = ?", "active", false)
+		if keyword := strings.TrimSpace(c.Query("query")); keyword != "" {
+			like := "%" + escapeLike(keyword) + "%"
+			query = query.Where(`username LIKE ? ESCAPE '\' OR display_name LIKE ? ESCAPE '\' OR class_group LIKE ? ESCAPE '\'`, like, like, like)
+		}
+
+		var users []User
+		query.Order("username asc").Limit(100).Find(&users)
+
+		result := []gin.H{}
+		for _, u := range users {
+			result = append(result, gin.H{
+				"username":     u.Username,
+				"display_name": u.Name(),
+				"avatar":       u.Avatar,
+				"role":         u.Role,
+				"pronouns":     u.Pronouns,
+				"class_group":  u.ClassGroup,
+			})
+		}
+		c.JSON(http.StatusOK, result)
+	})
+
+	// 获取我的屏蔽列表
+	r.GET("/api/me/blocks", authMiddleware, func(c *gin.Context) {
+		username := c.MustGet("username").(string)
+		var blocks []Block
+		db.Where("blocker = ?", username).Order("created_at desc").Find(&blocks)
+		c.JSON(http.StatusOK, blocks)
+	})
+
+	// 屏蔽用户
+	r.POST("/api/me/blocks/:username", authMiddleware, func(c *gin.Context) {
+		username := c.MustGet("username").(string)
+		target := c.Param("username")
+		if target == username {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "不能屏蔽自己"})
+			return
+		}
+		var count int64
+		db.Model(&User{}).Where("username = ?", target).Count(&count)
+		if count == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
 			return
 		}
-		files := form.File["files"]
-		paths := form.Value["paths"]
 
-		// 计算总大小
-		var totalSize int64 = 0
-		for _, file := range files {
-			totalSize += file.Size
+		block := Block{Blocker: username, Blocked: target}
+		if err := db.Where(&block).FirstOrCreate(&block).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "屏蔽失败"})
+			return
 		}
+		hub.setBlocked(username, target, true)
+		c.JSON(http.StatusOK, gin.H{"message": "已屏蔽该用户"})
+	})
 
-		// > 150MB = 150 * 1024 * 1024 = 157286400 bytes
-		needsApproval := totalSize > 157286400
+	// 取消屏蔽
+	r.DELETE("/api/me/blocks/:username", authMiddleware, func(c *gin.Context) {
+		username := c.MustGet("username").(string)
+		target := c.Param("username")
+		if err := db.Where("blocker = ? AND blocked = ?", username, target).Delete(&Block{}).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "取消屏蔽失败"})
+			return
+		}
+		hub.setBlocked(username, target, false)
+		c.JSON(http.StatusOK, gin.H{"message": "已取消屏蔽"})
+	})
 
-		var destDir string
-		if needsApproval {
-			// 如果超过150MB，放入临时目录，等admin审核
-			tempFolderName := fmt.Sprintf("%d_%s_%s", time.Now().Unix(), username, folderName)
-			destDir = "./temp_uploads/" + tempFolderName
-		} else {
-			destDir = fmt.Sprintf("./shared/%s_%s", username, folderName)
+	// ====== 文件共享路由 ======
+	initSharedStorage()
+	os.MkdirAll("./temp_uploads", os.ModePerm) // 待审核上传存储目录
+
+	// 加载当前用户的访问控制
+	loadACL := func(c *gin.Context) (*shareACL, bool) {
+		var user User
+		if err := db.Where("username = ?", c.MustGet("username").(string)).First(&user).Error; err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "用户不存在"})
+			return nil, false
 		}
+		return loadShareACL(&user), true
+	}
 
-		os.MkdirAll(destDir, os.ModePerm)
+	// 直接访问共享文件（通过逻辑路径查找内容，支持 Range）
+	r.GET("/shared/*filepath", authMiddleware, func(c *gin.Context) {
+		acl, ok := loadACL(c)
+		if !ok {
+			return
+		}
+		logicalPath, err := resolveSharedPath(strings.TrimPrefix(c.Param("filepath"), "/"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "路径无效: " + err.Error()})
+			return
+		}
+		// 无权访问时同样返回不存在，避免泄露私有文件名
+		item, err := findSharedFile(logicalPath)
+		if err != nil || !acl.canRead(item.Path, item.Owner) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "文件不存在"})
+			return
+		}
+		serveSharedFile(c, item, "file")
+	})
 
-		for i, file := range files {
-			relPath := ""
-			if i < len(paths) {
-				relPath = paths[i]
-			} else {
-				relPath = file.Filename
-			}
-			parts := strings.SplitN(relPath, "/", 2)
-			if len(parts) == 2 {
-				relPath = parts[1]
-			} else {
-				relPath = file.Filename
-			}
-			destPath := destDir + "/" + relPath
-			os.MkdirAll(filepath.Dir(destPath), os.ModePerm)
-			c.SaveUploadedFile(file, destPath)
+	// 文件预览：图片返回缩略图，文本返回第一页内容和语法片段。预览在后台生成，尚未生成时返回 202
+	r.GET("/api/preview", authMiddleware, func(c *gin.Context) {
+		acl, ok := loadACL(c)
+		if !ok {
+			return
+		}
+		logicalPath, err := resolveSharedPath(c.Query("path"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "路径无效"})
+			return
+		}
+		item, err := findSharedFile(logicalPath)
+		if err != nil || !acl.canRead(item.Path, item.Owner) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "文件不存在"})
+			return
+		}
+		kind := previewKind(item.Path, item.MIME)
+		if kind == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "该文件不支持预览"})
+			return
+		}
+		if previewFailed(item.Checksum, item.Path, item.MIME) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "无法生成预览"})
+			return
+		}
+		if !previewReady(item.Checksum, item.Path, item.MIME) {
+			queuePreview(item.Checksum, item.Path, item.MIME)
+			c.JSON(http.StatusAccepted, gin.H{"status": "pending"})
+			return
 		}
 
-		if needsApproval {
-			db.Create(&PendingUpload{
-				Username:   username,
-				FolderName: folderName,
-				TotalSize:  totalSize,
-				Status:     "pending",
-				TempPath:   destDir,
+		// 预览按内容哈希（文本还有语言）缓存，文件内容变化后 ETag 随之变化
+		etag := item.Checksum
+		if kind == "text" {
+			etag += "." + previewLanguage(item.Path)
+		}
+		c.Header("ETag", `"`+etag+`"`)
+		c.Header("Cache-Control", "private, no-cache")
+		if kind == "image" {
+			if c.Query("thumbnail") != "" {
+				c.File(thumbnailPath(item.Checksum))
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"type":          "image",
+				"thumbnail_url": "/api/preview?path=" + url.QueryEscape(item.Path) + "&thumbnail=1",
 			})
-			c.JSON(http.StatusOK, gin.H{"message": "上传成功，由于文件超过150MB，正在等待管理员审核", "status": "pending"})
 			return
 		}
-
-		c.JSON(http.StatusOK, gin.H{"message": "上传成功", "status": "approved"})
+		preview, err := loadTextPreview(item.Checksum, previewLanguage(item.Path))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "该文件不支持预览"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"type":      "text",
+			"language":  preview.Language,
+			"content":   preview.Content,
+			"lines":     preview.Lines,
+			"truncated": preview.Truncated,
+		})
 	})
 
+	// 上传文件夹（需登录）
+	registerUploadFolderRoute(r, authMiddleware)
+
 	// 上传单个文件（需登录）
 	r.POST("/api/upload-file", authMiddleware, func(c *gin.Context) {
 		username := c.MustGet("username").(string)
@@ -475,17 +785,30 @@ func main() {
 			return
 		}
 
-		// 目标目录：./shared/<username>_uploads/
-		destDir := fmt.Sprintf("./shared/%s_uploads", username)
-		os.MkdirAll(destDir, os.ModePerm)
+		decision := evaluateUploadPolicy(&user, []uploadEntry{{Name: file.Filename, Size: file.Size}})
+		if decision.Blocked {
+			c.JSON(http.StatusForbidden, gin.H{"error": decision.Reason})
+			return
+		}
+
+		if status, err := checkStorage(&user, file.Size); err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
 
 		// 安全处理文件名
-		safeFileName := strings.ReplaceAll(file.Filename, "..", "")
-		safeFileName = strings.Trim(safeFileName, "/\\")
-		destPath := filepath.Join(destDir, safeFileName)
+		safeFileName, err := cleanPathSegment(file.Filename)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "文件名无效: " + err.Error()})
+			return
+		}
+		if err := checkUploadTarget(username, username+"_uploads"); err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
 
-		// > 150MB 走审核
-		if file.Size > 157286400 {
+		// 按审核策略走审核
+		if decision.NeedsReview {
 			tempDir := fmt.Sprintf("./temp_uploads/%d_%s_file", time.Now().Unix(), username)
 			os.MkdirAll(tempDir, os.ModePerm)
 			tempPath := filepath.Join(tempDir, safeFileName)
@@ -493,18 +816,56 @@ func main() {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "保存文件失败"})
 				return
 			}
+			q, err := scanUpload(tempPath, safeFileName, joinSharedPath(username+"_uploads", safeFileName), username)
+			if err != nil {
+				os.RemoveAll(tempDir)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			if q != nil {
+				os.RemoveAll(tempDir)
+				c.JSON(http.StatusOK, gin.H{"message": "文件未通过安全检查，已被隔离等待管理员处理: " + q.Reason, "status": "quarantined"})
+				return
+			}
 			db.Create(&PendingUpload{
 				Username:   username,
 				FolderName: "uploads/" + safeFileName,
 				TotalSize:  file.Size,
 				Status:     "pending",
 				TempPath:   tempDir,
+				ReviewRule: decision.Rule,
 			})
-			c.JSON(http.StatusOK, gin.H{"message": "上传成功，由于文件超过150MB，正在等待管理员审核", "status": "pending"})
+			c.JSON(http.StatusOK, gin.H{"message": "上传成功，由于" + decision.Reason + "，正在等待管理员审核", "status": "pending"})
 			return
 		}
 
-		if err := c.SaveUploadedFile(file, destPath); err != nil {
+		// 目标位置：<username>_uploads/<文件名>
+		stageDir, err := newStagingDir()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "保存文件失败"})
+			return
+		}
+		defer os.RemoveAll(stageDir)
+		stagePath := filepath.Join(stageDir, "upload")
+		if err := c.SaveUploadedFile(file, stagePath); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "保存文件失败"})
+			return
+		}
+		target := joinSharedPath(username+"_uploads", safeFileName)
+		q, err := scanUpload(stagePath, safeFileName, target, username)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if q != nil {
+			c.JSON(http.StatusOK, gin.H{"message": "文件未通过安全检查，已被隔离等待管理员处理: " + q.Reason, "status": "quarantined"})
+			return
+		}
+		if _, err := putSharedFile(stagePath, target, username); err != nil {
+			if errors.Is(err, errNotOwner) {
+				c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "保存文件失败"})
 			return
 		}
@@ -512,19 +873,57 @@ func main() {
 		c.JSON(http.StatusOK, gin.H{"message": "上传成功", "status": "approved"})
 	})
 
+	// 大文件分片上传（断点续传）
+	registerChunkedUploadRoutes(r, authMiddleware)
+
+	// 聊天附件
+	registerAttachmentRoutes(r, authMiddleware)
+
+	// 访问权限和分享链接
+	registerShareRuleRoutes(r, authMiddleware)
+	registerShareLinkRoutes(r, authMiddleware)
+
+	// 文件搜索
+	registerSearchRoutes(r, authMiddleware)
+
+	// 站内通知
+	registerNotificationRoutes(r, authMiddleware)
+
+	// 查询当前用户的存储用量
+	r.GET("/api/me/usage", authMiddleware, func(c *gin.Context) {
+		username := c.MustGet("username").(string)
+		var user User
+		if err := db.Where("username = ?", username).First(&user).Error; err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "用户不存在"})
+			return
+		}
+
+		used := userStorageUsage(username)
+		pending := userPendingUsage(username)
+		quota := userQuota(&user)
+		result := gin.H{
+			"used":    used,
+			"pending": pending,
+			"quota":   quota, // 0 表示不限
+		}
+		if quota > 0 {
+			remaining := quota - used - pending
+			if remaining < 0 {
+				remaining = 0
+			}
+			result["remaining"] = remaining
+		}
+		c.JSON(http.StatusOK, result)
+	})
+
 	// 获取当前用户分享的文件夹列表（需登录）
 	r.GET("/api/my-folders", authMiddleware, func(c *gin.Context) {
 		username := c.MustGet("username").(string)
 		prefix := username + "_"
-		entries, err := os.ReadDir("./shared")
-		if err != nil {
-			c.JSON(http.StatusOK, []string{})
-			return
-		}
-		var folders []string
-		for _, e := range entries {
-			if e.IsDir() && strings.HasPrefix(e.Name(), prefix) {
-				folders = append(folders, strings.TrimPrefix(e.Name(), prefix))
+		folders := []string{}
+		for _, e := range listSharedDir("") {
+			if e.IsDir && e.Owner == username && strings.HasPrefix(e.Name, prefix) {
+				folders = append(folders, strings.TrimPrefix(e.Name, prefix))
 			}
 		}
 		c.JSON(http.StatusOK, folders)
@@ -532,66 +931,42 @@ func main() {
 
 	// 获取所有用户共享的文件夹（公共，需登录）
 	r.GET("/api/shared-folders", authMiddleware, func(c *gin.Context) {
-		subPath := c.Query("path")
-		subPath = strings.ReplaceAll(subPath, "..", "")
-		subPath = strings.Trim(subPath, "/\\")
-
-		baseDir := "./shared"
-		targetDir := baseDir
-		if subPath != "" {
-			targetDir = filepath.Join(baseDir, subPath)
-		}
-
-		entries, err := os.ReadDir(targetDir)
+		subPath, err := resolveSharedPath(c.Query("path"))
 		if err != nil {
-			c.JSON(http.StatusOK, []gin.H{})
+			c.JSON(http.StatusBadRequest, gin.H{"error": "路径无效: " + err.Error()})
 			return
 		}
 
-		var result []gin.H
-		for _, e := range entries {
-			info, _ := e.Info()
-			fullRelPath := e.Name()
-			if subPath != "" {
-				fullRelPath = subPath + "/" + e.Name()
-			}
-
-			item := gin.H{
-				"name":   e.Name(),
-				"path":   fullRelPath,
-				"is_dir": e.IsDir(),
-				"size":   int64(0),
-			}
+		acl, ok := loadACL(c)
+		if !ok {
+			return
+		}
 
-			if info != nil {
-				item["size"] = info.Size()
+		result := []gin.H{}
+		for _, e := range listSharedDir(subPath) {
+			if !acl.canRead(e.Path, e.Owner) {
+				continue
 			}
-
+			item := gin.H(e.toJSON())
+			item["visibility"] = acl.visibility(e.Path)
+			// 顶层目录名为 <所有者>_<文件夹名>，所有者取自元数据，文件夹名本身可以包含下划线
 			if subPath == "" {
-				parts := strings.SplitN(e.Name(), "_", 2)
-				if len(parts) == 2 {
-					item["owner"] = parts[0]
-					item["name"] = parts[1]
-				}
+				item["name"] = strings.TrimPrefix(e.Name, e.Owner+"_")
 			}
-
 			result = append(result, item)
 		}
-
-		if result == nil {
-			result = []gin.H{}
-		}
 		c.JSON(http.StatusOK, result)
 	})
 
 	// 删除分享文件夹（需登录，只能删除自己的）
 	r.DELETE("/api/delete-folder/:name", authMiddleware, func(c *gin.Context) {
 		username := c.MustGet("username").(string)
-		folderName := c.Param("name")
-		folderName = strings.ReplaceAll(folderName, "..", "")
-		folderName = strings.Trim(folderName, "/\\")
-		targetDir := fmt.Sprintf("./shared/%s_%s", username, folderName)
-		if err := os.RemoveAll(targetDir); err != nil {
+		folderName, err := cleanPathSegment(c.Param("name"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "文件夹名无效: " + err.Error()})
+			return
+		}
+		if _, err := trashSharedPath(fmt.Sprintf("%s_%s", username, folderName), username, username); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "删除失败"})
 			return
 		}
@@ -600,142 +975,120 @@ func main() {
 
 	// 下载文件夹（打包为 zip）
 	r.GET("/api/download-folder", authMiddleware, func(c *gin.Context) {
-		subPath := c.Query("path")
-		subPath = strings.ReplaceAll(subPath, "..", "")
-		subPath = strings.Trim(subPath, "/\\")
-
-		baseDir := "./shared"
-		targetDir := filepath.Join(baseDir, subPath)
+		acl, ok := loadACL(c)
+		if !ok {
+			return
+		}
+		subPath, err := resolveSharedPath(c.Query("path"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "路径无效: " + err.Error()})
+			return
+		}
 
-		info, err := os.Stat(targetDir)
-		if err != nil || !info.IsDir() {
+		var items []SharedItem
+		if subPath != "" && isSharedDir(subPath) {
+			items = acl.filterItems(sharedFilesUnder(subPath))
+		}
+		if len(items) == 0 {
 			c.JSON(http.StatusNotFound, gin.H{"error": "未找到目录"})
 			return
 		}
 
-		zipName := filepath.Base(targetDir)
-		if strings.Contains(zipName, "_") {
-			parts := strings.SplitN(zipName, "_", 2)
-			if len(parts) == 2 {
-				zipName = parts[1]
-			}
+		zipName := path.Base(subPath)
+		if !strings.Contains(subPath, "/") {
+			zipName = strings.TrimPrefix(zipName, items[0].Owner+"_")
 		}
 
-		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.zip\"", zipName))
-		c.Header("Content-Type", "application/zip")
+		entries := make([]zipEntry, len(items))
+		for i := range items {
+			entries[i] = zipEntry{Item: &items[i], Name: strings.TrimPrefix(items[i].Path, subPath+"/")}
+		}
+		serveSharedZip(c, zipName, entries, "folder")
+	})
 
-		zw := zip.NewWriter(c.Writer)
-		defer zw.Close()
+	// 批量下载指定文件和文件夹（打包为 zip）
+	r.GET("/api/batch-download", authMiddleware, func(c *gin.Context) {
+		acl, ok := loadACL(c)
+		if !ok {
+			return
+		}
+		paths := c.QueryArray("paths")
+		if len(paths) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "未提供下载路径"})
+			return
+		}
 
-		filepath.Walk(targetDir, func(path string, info os.FileInfo, err error) error {
+		var entries []zipEntry
+		for _, p := range paths {
+			subPath, err := resolveSharedPath(p)
 			if err != nil {
-				return err
-			}
-			if info.IsDir() {
-				return nil
+				c.JSON(http.StatusBadRequest, gin.H{"error": "路径无效: " + err.Error()})
+				return
 			}
-
-			relPath, err := filepath.Rel(targetDir, path)
-			if err != nil {
-				return err
+			if subPath == "" {
+				continue
 			}
 
-			return func() error {
-				f, err := os.Open(path)
-				if err != nil {
-					return err
-				}
-				defer f.Close()
-
-				w, err := zw.Create(relPath)
-				if err != nil {
-					return err
+			if item, err := findSharedFile(subPath); err == nil {
+				if acl.canRead(item.Path, item.Owner) {
+					entries = append(entries, zipEntry{Item: item, Name: path.Base(subPath)})
 				}
+				continue
+			}
 
-				_, err = io.Copy(w, f)
-				return err
-			}()
-		})
+			// 在 zip 内放在以该文件夹命名的目录下，保持相对于当前下载项的目录结构
+			items := acl.filterItems(sharedFilesUnder(subPath))
+			for i := range items {
+				relPath := strings.TrimPrefix(items[i].Path, subPath+"/")
+				entries = append(entries, zipEntry{Item: &items[i], Name: path.Base(subPath) + "/" + relPath})
+			}
+		}
+		if len(entries) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "没有可以下载的文件"})
+			return
+		}
+		serveSharedZip(c, "batch_download", entries, "batch")
 	})
 
-	// 批量下载指定文件和文件夹（打包为 zip）
-	r.GET("/api/batch-download", authMiddleware, func(c *gin.Context) {
-		paths := c.QueryArray("paths")
-		if len(paths) == 0 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "未提供下载路径"})
+	// 修改共享文件的说明（所有者或管理员）
+	r.POST("/api/files/description", authMiddleware, func(c *gin.Context) {
+		username := c.MustGet("username").(string)
+		var req struct {
+			Path        string `json:"path" binding:"required"`
+			Description string `json:"description"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+			return
+		}
+		req.Description = strings.TrimSpace(req.Description)
+		if utf8.RuneCountInString(req.Description) > 500 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "说明不能超过500个字符"})
+			return
+		}
+
+		logicalPath, err := resolveSharedPath(req.Path)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "路径无效: " + err.Error()})
+			return
+		}
+		item, err := findSharedFile(logicalPath)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "文件不存在"})
+			return
+		}
+		var user User
+		if err := db.Where("username = ?", username).First(&user).Error; err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "用户不存在"})
 			return
 		}
-
-		c.Header("Content-Disposition", "attachment; filename=\"batch_download.zip\"")
-		c.Header("Content-Type", "application/zip")
-
-		zw := zip.NewWriter(c.Writer)
-		defer zw.Close()
-
-		baseDir := "./shared"
-
-		for _, subPath := range paths {
-			subPath = strings.ReplaceAll(subPath, "..", "")
-			subPath = strings.Trim(subPath, "/\\")
-			targetDir := filepath.Join(baseDir, subPath)
-
-			info, err := os.Stat(targetDir)
-			if err != nil {
-				continue
-			}
-
-			if info.IsDir() {
-				err := filepath.Walk(targetDir, func(path string, fInfo os.FileInfo, err error) error {
-					if err != nil {
-						return err
-					}
-					if fInfo.IsDir() {
-						return nil
-					}
-					// 保持相对于当前下载项的目录结构
-					relPath, err := filepath.Rel(targetDir, path)
-					if err != nil {
-						return err
-					}
-					// 在 zip 内放在以该文件夹命名的目录下
-					zipPath := filepath.Join(info.Name(), relPath)
-					zipPath = strings.ReplaceAll(zipPath, "\\", "/")
-
-					return func() error {
-						f, err := os.Open(path)
-						if err != nil {
-							return err
-						}
-						defer f.Close()
-						w, err := zw.Create(zipPath)
-						if err != nil {
-							return err
-						}
-						_, err = io.Copy(w, f)
-						return err
-					}()
-				})
-				if err != nil {
-					log.Printf("Error walking directory for zip: %v", err)
-				}
-			} else {
-				if err := func() error {
-					f, err := os.Open(targetDir)
-					if err != nil {
-						return err
-					}
-					defer f.Close()
-					w, err := zw.Create(info.Name())
-					if err != nil {
-						return err
-					}
-					_, err = io.Copy(w, f)
-					return err
-				}(); err != nil {
-					log.Printf("Error adding file to zip: %v", err)
-				}
-			}
+		if item.Owner != username && user.Role != "admin" && user.Role != "system" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "只能修改自己共享的文件"})
+			return
 		}
+
+		db.Model(item).Update("description", req.Description)
+		c.JSON(http.StatusOK, gin.H{"message": "说明已更新"})
 	})
 
 	// 离线游戏静态资源
@@ -762,7 +1115,7 @@ func main() {
 	// 获取所有用户
 	adminGroup.GET("/users", func(c *gin.Context) {
 		var users []User
-		db.Select("id", "created_at", "username", "avatar", "role", "is_muted", "is_banned", "can_play_games", "can_share_files", "system_level").Find(&users)
+		db.Select("id", "created_at", "username", "display_name", "avatar", "role", "is_muted", "is_banned", "can_play_games", "can_share_files", "system_level", "status").Find(&users)
 		c.JSON(http.StatusOK, users)
 	})
 
@@ -815,32 +1168,46 @@ func main() {
 		}
 
 		// 防止路径穿越
-		subPath = strings.ReplaceAll(subPath, "..", "")
-		subPath = strings.Trim(subPath, "/\\")
-
-		targetDir := filepath.Join("./shared", subPath)
-
-		// 校验文件是否存在且位于 shared 目录
-		absTarget, err := filepath.Abs(targetDir)
-		if err != nil {
+		subPath, err := resolveSharedPath(subPath)
+		if err != nil || subPath == "" {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的路径"})
 			return
 		}
 
-		absShared, _ := filepath.Abs("./shared")
-		if !strings.HasPrefix(absTarget, absShared) {
-			c.JSON(http.StatusForbidden, gin.H{"error": "无法删除 shared 目录外的文件"})
+		count, err := trashSharedPath(subPath, "", c.MustGet("username").(string))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "删除失败: " + err.Error()})
 			return
 		}
-
-		if err := os.RemoveAll(targetDir); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "删除失败: " + err.Error()})
+		if count == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "文件(夹)不存在"})
 			return
 		}
 
 		c.JSON(http.StatusOK, gin.H{"message": "文件(夹)已删除"})
 	})
 
+	// 重命名、移动、复制、新建目录：普通用户限于自己的共享，管理员可操作任何位置
+	registerFileOpRoutes(r, authMiddleware, adminGroup)
+
+	// 删除单个文件、回收站
+	registerTrashRoutes(r, authMiddleware, adminGroup)
+
+	// 文件历史版本
+	registerVersionRoutes(r, authMiddleware, adminGroup)
+
+	// 上传文件安全检查与隔离区
+	registerQuarantineRoutes(adminGroup)
+
+	// 定期清理过期的待审核上传和遗留文件
+	registerJanitorRoutes(hub, adminGroup)
+
+	// 下载统计
+	registerDownloadStatsRoutes(r, authMiddleware, adminGroup)
+
+	// WebDAV 访问共享空间
+	registerDavRoutes(r, authMiddleware)
+
 	// 切换封禁状态
 	adminGroup.POST("/ban_user", func(c *gin.Context) {
 		var req struct {
@@ -998,6 +1365,8 @@ func main() {
 
 		// 使用 Unscoped 彻底删除，以修复后续无法再次注册同名用户的问题
 		db.Unscoped().Where("username = ?", targetUsername).Delete(&User{})
+		db.Where("blocker = ? OR blocked = ?", targetUsername, targetUsername).Delete(&Block{})
+		hub.loadBlocks()
 		hub.disconnectByUsername(targetUsername)
 
 		c.JSON(http.StatusOK, gin.H{"message": "用户删除成功"})
@@ -1098,109 +1467,451 @@ func main() {
 		c.JSON(http.StatusOK, gin.H{"message": "系统级密码修改成功"})
 	})
 
-	// ====== 审核相关接口 ======
-	// 获取待审核列表
-	adminGroup.GET("/pending_uploads", func(c *gin.Context) {
-		var pending []PendingUpload
-		db.Where("status = ?", "pending").Find(&pending)
-		c.JSON(http.StatusOK, pending)
+	// ====== 上传审核策略接口 ======
+	// 获取上传审核策略
+	adminGroup.GET("/upload_policy", func(c *gin.Context) {
+		c.JSON(http.StatusOK, getUploadPolicy())
 	})
 
-	// 同意上传
-	adminGroup.POST("/approve_upload", func(c *gin.Context) {
+	// 修改上传审核策略
+	adminGroup.POST("/upload_policy", func(c *gin.Context) {
+		var policy UploadPolicy
+		if err := c.ShouldBindJSON(&policy); err != nil || policy.SizeThreshold < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+			return
+		}
+		for _, role := range append(append([]string{}, policy.AutoApproveRoles...), policy.ReviewAllRoles...) {
+			if _, ok := defaultRoleQuotas[role]; !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "无效的角色: " + role})
+				return
+			}
+		}
+		if err := setUploadPolicy(policy); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失败"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "上传审核策略已更新", "policy": getUploadPolicy()})
+	})
+
+	// ====== 存储配额接口 ======
+	// 获取角色默认配额和磁盘保留空间
+	adminGroup.GET("/quota_settings", func(c *gin.Context) {
+		quotas := gin.H{}
+		for role := range defaultRoleQuotas {
+			quotas[role] = roleQuota(role)
+		}
+		result := gin.H{
+			"role_quotas":   quotas,
+			"min_free_disk": getConfigInt("min_free_disk", defaultMinFreeDisk),
+			"zip_max_size":  getConfigInt("zip_max_size", defaultZipMaxSize),
+		}
+		if free, err := diskFreeBytes(blobDir); err == nil {
+			result["disk_free"] = free
+		}
+		c.JSON(http.StatusOK, result)
+	})
+
+	// 修改角色默认配额、磁盘保留空间和打包下载上限（仅 system）
+	adminGroup.POST("/quota_settings", func(c *gin.Context) {
+		if c.MustGet("role").(string) != "system" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "只有 system 角色可执行此操作"})
+			return
+		}
 		var req struct {
-			ID uint `json:"id" binding:"required"`
+			RoleQuotas  map[string]int64 `json:"role_quotas"`
+			MinFreeDisk *int64           `json:"min_free_disk"`
+			ZipMaxSize  *int64           `json:"zip_max_size"` // 0 表示不限
 		}
 		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
 			return
 		}
+		for role, quota := range req.RoleQuotas {
+			if _, ok := defaultRoleQuotas[role]; !ok || quota < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "无效的角色或配额"})
+				return
+			}
+		}
+		if req.MinFreeDisk != nil && *req.MinFreeDisk < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的磁盘保留空间"})
+			return
+		}
+		if req.ZipMaxSize != nil && *req.ZipMaxSize < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的打包下载上限"})
+			return
+		}
 
-		var pending PendingUpload
-		if err := db.First(&pending, req.ID).Error; err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "不存在该审核记录"})
+		for role, quota := range req.RoleQuotas {
+			setConfigValue("quota_"+role, strconv.FormatInt(quota, 10))
+		}
+		if req.MinFreeDisk != nil {
+			setConfigValue("min_free_disk", strconv.FormatInt(*req.MinFreeDisk, 10))
+		}
+		if req.ZipMaxSize != nil {
+			setConfigValue("zip_max_size", strconv.FormatInt(*req.ZipMaxSize, 10))
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "配额设置已更新"})
+	})
+
+	// 查看用户存储用量
+	adminGroup.GET("/usage/:username", func(c *gin.Context) {
+		var target User
+		if err := db.Where("username = ?", c.Param("username")).First(&target).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
 			return
 		}
+		c.JSON(http.StatusOK, gin.H{
+			"username":      target.Username,
+			"used":          userStorageUsage(target.Username),
+			"pending":       userPendingUsage(target.Username),
+			"quota":         userQuota(&target),
+			"storage_quota": target.StorageQuota,
+		})
+	})
 
-		if pending.Status != "pending" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "该记录已被处理"})
+	// 单独设置用户配额，quota 为 null 时恢复为角色默认值
+	adminGroup.POST("/quota", func(c *gin.Context) {
+		var req struct {
+			Username string `json:"username" binding:"required"`
+			Quota    *int64 `json:"quota"` // 字节，0 表示不限
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || (req.Quota != nil && *req.Quota < 0) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
 			return
 		}
+		callerRole := c.MustGet("role").(string)
 
-		destDir := fmt.Sprintf("./shared/%s_%s", pending.Username, pending.FolderName)
+		var target User
+		if err := db.Where("username = ?", req.Username).First(&target).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+			return
+		}
+		if callerRole == "admin" && target.Role != "user" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "无法操作同级或更高级别用户"})
+			return
+		}
 
-		// 将相对路径中的 / 统一为系统分隔符处理目标路径
-		destDir = filepath.Clean(destDir)
+		if err := db.Model(&User{}).Where("username = ?", req.Username).Update("storage_quota", req.Quota).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失败"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "配额设置成功"})
+	})
 
-		// 移动临时文件/文件夹到 shared 目录下
-		if err := os.Rename(pending.TempPath, destDir); err != nil {
-			// 如果在不同挂载点 Rename 可能会抛出 cross-device link 错误
-			// 实现跨盘或 fallback 复制机制
-			errCopy := filepath.Walk(pending.TempPath, func(path string, info os.FileInfo, err error) error {
-				if err != nil {
-					return err
-				}
-				relPath, err := filepath.Rel(pending.TempPath, path)
-				if err != nil {
-					return err
-				}
-				targetPath := filepath.Join(destDir, relPath)
-				if info.IsDir() {
-					return os.MkdirAll(targetPath, info.Mode())
-				}
-				srcFile, err := os.Open(path)
-				if err != nil {
-					return err
-				}
-				defer srcFile.Close()
-				dstFile, err := os.OpenFile(targetPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode())
-				if err != nil {
-					return err
-				}
-				defer dstFile.Close()
-				_, err = io.Copy(dstFile, srcFile)
-				return err
-			})
+	// 设置用户的班级/小组，按小组共享的文件以此判断成员
+	adminGroup.POST("/class_group", func(c *gin.Context) {
+		var req struct {
+			Username   string `json:"username" binding:"required"`
+			ClassGroup string `json:"class_group"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+			return
+		}
+		classGroup, err := normalizeProfileField("class_group", req.ClassGroup)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		callerRole := c.MustGet("role").(string)
 
-			if errCopy != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "移动文件失败且复制回退也失败: " + errCopy.Error()})
-				return
-			}
-			// 复制成功，删除原临时目录
-			os.RemoveAll(pending.TempPath)
+		var target User
+		if err := db.Where("username = ?", req.Username).First(&target).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+			return
+		}
+		if callerRole == "admin" && target.Role != "user" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "无法操作同级或更高级别用户"})
+			return
 		}
 
-		db.Model(&pending).Update("status", "approved")
+		if err := db.Model(&User{}).Where("username = ?", req.Username).Update("class_group", classGroup).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失败"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "班级/小组设置成功"})
+	})
 
-		c.JSON(http.StatusOK, gin.H{"message": "审核通过"})
+	// ====== 注册策略接口 ======
+	// 获取注册模式
+	adminGroup.GET("/registration", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"mode": getRegistrationMode()})
 	})
 
-	// 拒绝上传
-	adminGroup.POST("/reject_upload", func(c *gin.Context) {
+	// 修改注册模式
+	adminGroup.POST("/registration", func(c *gin.Context) {
+		var req struct {
+			Mode string `json:"mode" binding:"required"` // open, invite, approval, closed
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || !isValidRegistrationMode(req.Mode) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+			return
+		}
+		if err := setConfigValue("registration_mode", req.Mode); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失败"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "注册模式已更新"})
+	})
+
+	// 获取邀请码列表
+	adminGroup.GET("/invite_codes", func(c *gin.Context) {
+		var codes []InviteCode
+		db.Order("created_at desc").Find(&codes)
+		c.JSON(http.StatusOK, codes)
+	})
+
+	// 生成邀请码
+	adminGroup.POST("/invite_codes", func(c *gin.Context) {
+		var req struct {
+			Code           string `json:"code"`             // 留空则随机生成
+			MaxUses        int    `json:"max_uses"`         // 0 表示不限次数
+			ExpiresInHours int    `json:"expires_in_hours"` // 0 表示永不过期
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || req.MaxUses < 0 || req.ExpiresInHours < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+			return
+		}
+
+		invite := InviteCode{
+			Code:      strings.TrimSpace(req.Code),
+			CreatedBy: c.MustGet("username").(string),
+			MaxUses:   req.MaxUses,
+		}
+		if invite.Code == "" {
+			invite.Code = generateInviteCode()
+		}
+		if req.ExpiresInHours > 0 {
+			expiresAt := time.Now().Add(time.Duration(req.ExpiresInHours) * time.Hour)
+			invite.ExpiresAt = &expiresAt
+		}
+
+		if err := db.Create(&invite).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "邀请码已存在"})
+			return
+		}
+		c.JSON(http.StatusOK, invite)
+	})
+
+	// 删除邀请码
+	adminGroup.DELETE("/invite_codes/:code", func(c *gin.Context) {
+		if err := db.Where("code = ?", c.Param("code")).Delete(&InviteCode{}).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "删除失败"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "邀请码已删除"})
+	})
+
+	// 获取待审核账号列表
+	adminGroup.GET("/pending_users", func(c *gin.Context) {
+		var users []User
+		db.Select("id", "created_at", "username", "display_name", "avatar", "register_ip", "invite_code", "status").
+			Where("status = ?", "pending").Order("created_at asc").Find(&users)
+		c.JSON(http.StatusOK, users)
+	})
+
+	// 通过账号审核
+	adminGroup.POST("/approve_user", func(c *gin.Context) {
+		var req struct {
+			Username string `json:"username" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+			return
+		}
+		result := db.Model(&User{}).Where("username = ? AND status = ?", req.Username, "pending").Update("status", "active")
+		if result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失败"})
+			return
+		}
+		if result.RowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "不存在该待审核账号"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "账号已通过审核"})
+	})
+
+	// 拒绝账号注册（直接删除，允许重新注册同名账号）
+	adminGroup.POST("/reject_user", func(c *gin.Context) {
 		var req struct {
-			ID uint `json:"id" binding:"required"`
+			Username string `json:"username" binding:"required"`
 		}
 		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
 			return
 		}
+		result := db.Unscoped().Where("username = ? AND status = ?", req.Username, "pending").Delete(&User{})
+		if result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "删除失败"})
+			return
+		}
+		if result.RowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "不存在该待审核账号"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "已拒绝该账号的注册"})
+	})
+
+	// ====== 审核相关接口 ======
+	// 获取待审核列表
+	adminGroup.GET("/pending_uploads", func(c *gin.Context) {
+		var pending []PendingUpload
+		db.Where("status = ?", "pending").Find(&pending)
+		c.JSON(http.StatusOK, pending)
+	})
+
+	// 查看待审核上传中的文件
+	adminGroup.GET("/pending_uploads/:id/files", func(c *gin.Context) {
+		var pending PendingUpload
+		if err := db.Where("id = ?", c.Param("id")).First(&pending).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "不存在该审核记录"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"upload":      pending,
+			"destination": pendingLogicalDir(&pending),
+			"files":       listPendingFiles(&pending),
+		})
+	})
 
+	// 下载待审核的单个文件以便检查内容
+	adminGroup.GET("/pending_uploads/:id/file", func(c *gin.Context) {
 		var pending PendingUpload
-		if err := db.First(&pending, req.ID).Error; err != nil {
+		if err := db.Where("id = ?", c.Param("id")).First(&pending).Error; err != nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "不存在该审核记录"})
 			return
 		}
+		files, err := selectPendingFiles(&pending, []string{c.Query("path")})
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		src, err := safeJoin(pending.TempPath, files[0].Path)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "文件路径无效"})
+			return
+		}
+		c.Header("X-Content-Type-Options", "nosniff")
+		c.FileAttachment(src, path.Base(files[0].Path))
+	})
 
+	// 审核请求：files 为空时处理全部剩余文件
+	type reviewRequest struct {
+		ID       uint     `json:"id" binding:"required"`
+		Files    []string `json:"files"`
+		Reason   string   `json:"reason"`
+		Conflict string   `json:"conflict"` // 通过时目标位置已有同名文件：overwrite（默认，旧内容保留为历史版本）或 rename
+	}
+	// 加载待审核记录并加锁，成功时调用方处理完后需要调用 unlock
+	loadReview := func(c *gin.Context) (pending *PendingUpload, req *reviewRequest, files []pendingFile, unlock func(), ok bool) {
+		req = &reviewRequest{}
+		if err := c.ShouldBindJSON(req); err != nil || (req.Conflict != "" && req.Conflict != ConflictOverwrite && req.Conflict != ConflictRename) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+			return nil, nil, nil, nil, false
+		}
+		unlock = lockPendingUpload(req.ID)
+		fail := func(status int, msg string) (*PendingUpload, *reviewRequest, []pendingFile, func(), bool) {
+			unlock()
+			c.JSON(status, gin.H{"error": msg})
+			return nil, nil, nil, nil, false
+		}
+		pending = &PendingUpload{}
+		if err := db.First(pending, req.ID).Error; err != nil {
+			return fail(http.StatusNotFound, "不存在该审核记录")
+		}
 		if pending.Status != "pending" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "该记录已被处理"})
+			return fail(http.StatusBadRequest, "该记录已被处理")
+		}
+		files, err := selectPendingFiles(pending, req.Files)
+		if err != nil {
+			return fail(http.StatusBadRequest, err.Error())
+		}
+		if len(files) == 0 {
+			return fail(http.StatusBadRequest, "没有待审核的文件")
+		}
+		return pending, req, files, unlock, true
+	}
+	withReason := func(msg, reason string) string {
+		if reason = strings.TrimSpace(reason); reason != "" {
+			return msg + "，理由：" + reason
+		}
+		return msg
+	}
+
+	// 同意上传（可以只通过其中部分文件）
+	adminGroup.POST("/approve_upload", func(c *gin.Context) {
+		pending, req, files, unlock, ok := loadReview(c)
+		if !ok {
+			return
+		}
+		defer unlock()
+		reviewer := c.MustGet("username").(string)
+
+		approved := []string{}
+		approvedFiles := []string{}
+		quarantined := []string{}
+		failed := gin.H{}
+		for _, f := range files {
+			dst, err := approvePendingFile(pending, f.Path, req.Conflict)
+			if err != nil {
+				// 未通过安全检查的文件已移入隔离区，按拒绝处理
+				if errors.Is(err, errQuarantined) {
+					quarantined = append(quarantined, f.Path)
+					pending.RejectedFiles = append(pending.RejectedFiles, f.Path)
+				}
+				failed[f.Path] = err.Error()
+				continue
+			}
+			approved = append(approved, dst)
+			approvedFiles = append(approvedFiles, f.Path)
+			pending.ApprovedFiles = append(pending.ApprovedFiles, f.Path)
+		}
+		if len(approved) == 0 && len(quarantined) == 0 {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "移动文件失败", "failed": failed})
 			return
 		}
+		if len(approved) > 0 {
+			recordReviewDecision(pending, "approved", approvedFiles, req.Reason, reviewer)
+		}
+		if len(quarantined) > 0 {
+			recordReviewDecision(pending, "quarantined", quarantined, "未通过安全检查", reviewer)
+		}
+		finishPendingReview(pending)
+
+		if len(approved) > 0 {
+			notifyUser(hub, pending.Username, "upload_approved", withReason(
+				fmt.Sprintf("您上传的「%s」中有 %d 个文件已通过审核", pendingDisplayName(pending), len(approved)), req.Reason))
+		}
+		if len(quarantined) > 0 {
+			notifyUser(hub, pending.Username, "upload_rejected",
+				fmt.Sprintf("您上传的「%s」中有 %d 个文件未通过安全检查，已被隔离等待管理员处理", pendingDisplayName(pending), len(quarantined)))
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "审核通过" + quarantineNotice(quarantined), "approved": approved, "quarantined": quarantined, "failed": failed, "status": pending.Status})
+	})
 
-		// 删除临时文件
-		os.RemoveAll(pending.TempPath)
-		db.Model(&pending).Update("status", "rejected")
+	// 拒绝上传（可以只拒绝其中部分文件），被拒绝的文件移入回收站
+	adminGroup.POST("/reject_upload", func(c *gin.Context) {
+		pending, req, files, unlock, ok := loadReview(c)
+		if !ok {
+			return
+		}
+		defer unlock()
+		reviewer := c.MustGet("username").(string)
+		if err := trashPendingFiles(pending, files, reviewer); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "操作失败: " + err.Error()})
+			return
+		}
+		rejected := make([]string, 0, len(files))
+		for _, f := range files {
+			rejected = append(rejected, f.Path)
+		}
+		pending.RejectedFiles = append(pending.RejectedFiles, rejected...)
+		recordReviewDecision(pending, "rejected", rejected, req.Reason, reviewer)
+		finishPendingReview(pending)
 
-		c.JSON(http.StatusOK, gin.H{"message": "已拒绝该文件的分享"})
+		notifyUser(hub, pending.Username, "upload_rejected", withReason(
+			fmt.Sprintf("您上传的「%s」中有 %d 个文件未通过审核", pendingDisplayName(pending), len(files)), req.Reason))
+		c.JSON(http.StatusOK, gin.H{"message": "已拒绝该文件的分享", "status": pending.Status})
 	})
 
 	// 嵌入的前端静态资源
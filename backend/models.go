@@ -13,7 +13,9 @@ type User struct {
 	UpdatedAt     time.Time      `json:"updated_at"`
 	DeletedAt     gorm.DeletedAt `gorm:"index" json:"-"`
 	Username      string         `gorm:"uniqueIndex;type:varchar(12)" json:"username"`
-	Password      string         `json:"-"` // 不在 JSON 中返回密码
+	DisplayName   string         `json:"display_name"`   // 显示名，支持中文等 Unicode 字符
+	DisplayKey    string         `gorm:"index" json:"-"` // 显示名骨架，用于查重和防仿冒
+	Password      string         `json:"-"`              // 不在 JSON 中返回密码
 	Avatar        string         `json:"avatar"`
 	Role          string         `json:"role"`
 	IsMuted       bool           `json:"is_muted"`                            // 禁言
@@ -21,21 +23,80 @@ type User struct {
 	CanPlayGames  bool           `json:"can_play_games" gorm:"default:true"`  // 是否可以玩游戏
 	CanShareFiles bool           `json:"can_share_files" gorm:"default:true"` // 是否可以共享文件
 	SystemLevel   int            `json:"system_level" gorm:"default:0"`       // 0=非system, 1=主system(/system认证), 2=副system(主system分发)
+	Status        string         `json:"status" gorm:"default:active"`        // active=正常, pending=等待管理员审核
+	RegisterIP    string         `json:"register_ip"`                         // 注册时的IP
+	InviteCode    string         `json:"invite_code"`                         // 注册时使用的邀请码
+	Bio           string         `json:"bio"`                                 // 个人简介
+	Pronouns      string         `json:"pronouns"`                            // 人称代词
+	ClassGroup    string         `json:"class_group"`                         // 班级/小组，由管理员设置
+	Contact       string         `json:"contact"`                             // 联系方式
+	HideFromDir   bool           `json:"hide_from_directory"`                 // 不在成员目录中显示
+	MessageCount  int64          `json:"message_count" gorm:"default:0"`      // 累计发送消息数
+	StorageQuota  *int64         `json:"storage_quota"`                       // 管理员单独设置的存储配额（字节），nil=按角色默认，0=不限
 }
 
 // Message 消息模型
 type Message struct {
-	ID         uint           `gorm:"primarykey" json:"id"`
-	CreatedAt  time.Time      `json:"-"`
-	UpdatedAt  time.Time      `json:"-"`
-	DeletedAt  gorm.DeletedAt `gorm:"index" json:"-"`
-	Sender     string         `json:"sender"`      // 发送者ID/地址 (Identifier)
-	SenderName string         `json:"sender_name"` // 发送者昵称
-	Avatar     string         `json:"avatar"`      // 头像
-	Content    string         `json:"content"`     // 内容
-	Time       string         `json:"time"`        // 格式化时间 "15:04"
-	Type       string         `json:"type"`        // 消息类型: user, system, force_disconnect
-	Role       string         `json:"role"`        // 角色: user, admin
+	ID         uint            `gorm:"primarykey" json:"id"`
+	CreatedAt  time.Time       `json:"-"`
+	UpdatedAt  time.Time       `json:"-"`
+	DeletedAt  gorm.DeletedAt  `gorm:"index" json:"-"`
+	Sender     string          `json:"sender"`                        // 发送者ID/地址 (Identifier)
+	Username   string          `json:"username"`                      // 发送者登录用户名，由服务端根据认证身份填写
+	SenderName string          `json:"sender_name"`                   // 发送者昵称
+	Avatar     string          `json:"avatar"`                        // 头像
+	Content    string          `json:"content"`                       // 内容
+	Time       string          `json:"time"`                          // 格式化时间 "15:04"
+	Type       string          `json:"type"`                          // 消息类型: user, system, force_disconnect
+	Role       string          `json:"role"`                          // 角色: user, admin
+	Attachment *attachmentInfo `json:"attachment,omitempty" gorm:"-"` // 聊天附件
+
+	Notification *Notification `json:"notification,omitempty" gorm:"-"` // type=notification 时附带的通知
+}
+
+// Notification 发给用户的站内通知，如上传审核结果
+type Notification struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Username  string    `gorm:"index" json:"-"`
+	Kind      string    `json:"kind"` // upload_approved, upload_rejected
+	Content   string    `json:"content"`
+	Read      bool      `json:"read" gorm:"default:false"`
+}
+
+// DownloadRecord 共享文件的一次下载
+type DownloadRecord struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"downloaded_at"`
+	Username  string    `gorm:"index" json:"username"` // 通过分享链接下载时为空
+	ItemID    uint      `gorm:"index" json:"item_id"`
+	Path      string    `json:"path"` // 下载时的路径
+	Owner     string    `json:"owner"`
+	Bytes     int64     `json:"bytes"`  // 实际发送的字节数
+	Source    string    `json:"source"` // file, folder, batch, link
+}
+
+// AppPassword 应用专用密码，用于 WebDAV 等无法使用网页登录的客户端
+type AppPassword struct {
+	ID         uint       `gorm:"primarykey" json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Username   string     `gorm:"index" json:"-"`
+	Name       string     `json:"name"` // 用户填写的用途，如 “实验室电脑”
+	Hash       string     `gorm:"index" json:"-"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// QuarantineItem 未通过安全检查的上传文件，等待管理员放行或删除
+type QuarantineItem struct {
+	ID          uint      `gorm:"primarykey" json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Username    string    `gorm:"index" json:"username"` // 上传者
+	Name        string    `json:"name"`
+	Destination string    `json:"destination"` // 放行后放入的共享路径，聊天附件为空
+	Size        int64     `json:"size"`
+	Scanner     string    `json:"scanner"` // 发现问题的检查器
+	Reason      string    `json:"reason"`
+	StoragePath string    `json:"-"`
 }
 
 // IPBan IP封禁模型
@@ -56,7 +117,157 @@ type PendingUpload struct {
 	CreatedAt  time.Time `json:"created_at"`
 	Username   string    `json:"username"`
 	FolderName string    `json:"folder_name"`
-	TotalSize  int64     `json:"total_size"` // 字节
-	Status     string    `json:"status"`     // pending, approved, rejected
-	TempPath   string    `json:"-"`          // 临时存储路径
+	TotalSize  int64     `json:"total_size"`  // 字节
+	Status     string    `json:"status"`      // pending, approved, rejected, partial（部分通过）, expired（超时未审核）
+	TempPath   string    `json:"-"`           // 临时存储路径
+	ReviewRule string    `json:"review_rule"` // 触发审核的规则，如 size>150.0 MB、extension:.exe、role:user
+
+	ApprovedFiles []string `gorm:"serializer:json" json:"approved_files"` // 已通过的文件（相对路径）
+	RejectedFiles []string `gorm:"serializer:json" json:"rejected_files"` // 已拒绝的文件
+	Reason        string   `json:"reason"`                                // 最近一次审核填写的理由
+	ReviewedBy    string   `json:"reviewed_by"`
+
+	Decisions []reviewDecision `gorm:"serializer:json" json:"decisions"` // 每次审核的结果和理由，部分通过时会有多次
+}
+
+// InviteCode 邀请码模型
+type InviteCode struct {
+	Code      string     `gorm:"primarykey" json:"code"`
+	CreatedAt time.Time  `json:"created_at"`
+	CreatedBy string     `json:"created_by"`
+	MaxUses   int        `json:"max_uses"`   // 最大使用次数，0 表示不限
+	UsedCount int        `json:"used_count"` // 已使用次数
+	ExpiresAt *time.Time `json:"expires_at"` // 过期时间，nil 表示永不过期
+}
+
+// Block 用户屏蔽关系：Blocker 不再收到 Blocked 发送的消息
+type Block struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Blocker   string    `gorm:"uniqueIndex:idx_block_pair" json:"blocker"`
+	Blocked   string    `gorm:"uniqueIndex:idx_block_pair" json:"blocked"`
+}
+
+// UploadSession 分片上传会话，支持断点续传
+type UploadSession struct {
+	ID         string          `gorm:"primarykey" json:"id"`
+	CreatedAt  time.Time       `json:"created_at"`
+	UpdatedAt  time.Time       `json:"updated_at"` // 最后一次收到分片的时间，用于清理废弃会话
+	Username   string          `gorm:"index" json:"username"`
+	Type       string          `json:"type"`        // file=单文件上传, folder=文件夹上传
+	FolderName string          `json:"folder_name"` // 文件夹上传时的文件夹名
+	TotalSize  int64           `json:"total_size"`
+	Files      []UploadedFile  `gorm:"serializer:json" json:"files"`
+	Decision   *uploadDecision `gorm:"serializer:json" json:"-"` // 第一次完成时的策略判定，完成中途失败后重试沿用同一结果
+}
+
+// UploadedFile 分片上传会话中的单个文件
+type UploadedFile struct {
+	Path        string `json:"path"`                  // 相对路径（文件夹上传时包含顶层文件夹名）
+	Size        int64  `json:"size"`                  // 声明的文件大小
+	SHA256      string `json:"sha256"`                // 可选，整个文件的校验和
+	Done        bool   `json:"done,omitempty"`        // 完成上传时已导入（或已移入审核目录），重试时跳过
+	Quarantined bool   `json:"quarantined,omitempty"` // 完成上传时未通过安全检查，已被隔离
+}
+
+// SharedItem 共享文件元数据，记录逻辑路径到内容（blob）的映射
+type SharedItem struct {
+	ID            uint      `gorm:"primarykey" json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	Owner         string    `gorm:"index" json:"owner"`      // 所有者用户名
+	Path          string    `gorm:"uniqueIndex" json:"path"` // 逻辑路径，如 alice_课件/第一章.pdf
+	Checksum      string    `gorm:"index" json:"checksum"`   // 内容的 SHA-256，对应 blobs 下的文件
+	Size          int64     `json:"size"`
+	MIME          string    `json:"mime"`        // 按内容识别的 MIME 类型
+	Description   string    `json:"description"` // 所有者填写的说明
+	DownloadCount int64     `json:"download_count" gorm:"default:0"`
+	CRC32         *uint32   `json:"-" gorm:"column:crc32"` // 内容的 CRC-32，首次以存储方式打包时计算，用于预先确定 zip 大小
+}
+
+// SharedFolder 手动创建的目录。包含文件的目录由文件路径隐含，这里只需要记录可能为空的目录
+type SharedFolder struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Owner     string    `gorm:"index" json:"owner"`
+	Path      string    `gorm:"uniqueIndex" json:"path"`
+}
+
+// FileVersion 文件被覆盖前的历史版本，内容仍在 blob 存储中，按路径关联到当前文件。
+// 文件移入回收站时版本随之移入（TrashID 不为 0），不会被之后在原路径上新建的文件继承
+type FileVersion struct {
+	ID         uint      `gorm:"primarykey" json:"id"`
+	CreatedAt  time.Time `json:"replaced_at"` // 被覆盖的时间
+	Path       string    `gorm:"index" json:"path"`
+	Owner      string    `gorm:"index" json:"owner"`
+	Checksum   string    `gorm:"index" json:"checksum"`
+	Size       int64     `json:"size"`
+	MIME       string    `json:"mime"`
+	ModifiedAt time.Time `json:"modified_at"`    // 该版本上传的时间
+	TrashID    uint      `gorm:"index" json:"-"` // 所在的回收站记录，0 表示文件仍在共享空间中
+}
+
+// SearchContent 文本文件内容的搜索索引，按内容哈希存储，相同内容只索引一次
+type SearchContent struct {
+	Checksum string `gorm:"primarykey"`
+	Content  string
+}
+
+// ShareRule 共享文件(夹)的访问规则，作用于该路径及其下所有内容，子路径上的规则优先；没有规则时所有登录用户可见
+type ShareRule struct {
+	ID         uint      `gorm:"primarykey" json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	Path       string    `gorm:"uniqueIndex" json:"path"`
+	Owner      string    `gorm:"index" json:"owner"`
+	Visibility string    `json:"visibility"`                   // private, users, group, everyone, link
+	Users      []string  `gorm:"serializer:json" json:"users"` // visibility=users 时允许访问的用户
+	Group      string    `json:"group"`                        // visibility=group 时允许访问的班级/小组
+}
+
+// ShareLink 共享文件(夹)的分享链接，持有链接即可匿名下载
+type ShareLink struct {
+	Token         string     `gorm:"primarykey" json:"token"`
+	CreatedAt     time.Time  `json:"created_at"`
+	Owner         string     `gorm:"index" json:"owner"`
+	Path          string     `gorm:"index" json:"path"`
+	Password      string     `json:"-"`          // bcrypt 哈希，为空表示不需要密码
+	ExpiresAt     *time.Time `json:"expires_at"` // nil 表示永不过期
+	DownloadCount int64      `json:"download_count" gorm:"default:0"`
+}
+
+// TrashEntry 回收站中的一次删除。共享文件只记录元数据，内容仍保留在 blob 存储中；
+// 被拒绝的待审核文件则移到 trash 目录下。保留期过后自动清除
+type TrashEntry struct {
+	ID          uint          `gorm:"primarykey" json:"id"`
+	CreatedAt   time.Time     `gorm:"index" json:"deleted_at"`
+	Kind        string        `gorm:"default:shared" json:"kind"` // shared, upload
+	Owner       string        `gorm:"index" json:"owner"`
+	Path        string        `json:"path"` // 删除前的逻辑路径；待审核上传为上传时的文件夹名
+	DeletedBy   string        `json:"deleted_by"`
+	Size        int64         `json:"size"`
+	Files       []trashedFile `gorm:"serializer:json" json:"files"`
+	Folders     []string      `gorm:"serializer:json" json:"folders"`
+	UploadID    uint          `json:"upload_id,omitempty"` // 被拒绝的文件所属的待审核上传记录
+	StoragePath string        `json:"-"`                   // 被拒绝的文件在 trash 目录下的位置
+}
+
+// TrashedBlob 回收站记录引用的 blob，清理 blob 时按哈希直接查询，不必解析每条记录中的文件列表
+type TrashedBlob struct {
+	ID       uint   `gorm:"primarykey"`
+	TrashID  uint   `gorm:"index"`
+	Checksum string `gorm:"index"`
+}
+
+// Attachment 聊天附件，内容与共享文件一样存放在 blob 存储中
+type Attachment struct {
+	ID        string    `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Uploader  string    `gorm:"index" json:"uploader"`
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	MIME      string    `json:"mime"`
+	Checksum  string    `gorm:"index" json:"-"`
+	Thumbnail bool      `json:"-"`                      // 是否生成了缩略图
+	Sent      bool      `json:"-" gorm:"default:false"` // 是否已随消息发出，未发出的附件过期后删除
 }
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 保存一条通知，用户在线时立即通过 WebSocket 推送
+func notifyUser(hub *Hub, username, kind, content string) {
+	n := Notification{Username: username, Kind: kind, Content: content}
+	if err := db.Create(&n).Error; err != nil {
+		return
+	}
+	hub.sendToUser(username, Message{
+		Sender:       "system",
+		SenderName:   "系统",
+		Content:      content,
+		Time:         time.Now().Format("15:04"),
+		Type:         "notification",
+		Role:         "user",
+		Notification: &n,
+	})
+}
+
+func registerNotificationRoutes(r *gin.Engine, authMiddleware gin.HandlerFunc) {
+	// 获取自己的通知，最新的在前；unread=1 时只返回未读的
+	r.GET("/api/notifications", authMiddleware, func(c *gin.Context) {
+		query := db.Where("username = ?", c.MustGet("username").(string))
+		if c.Query("unread") == "1" {
+			query = query.Where("read = ?", false)
+		}
+		var notifications []Notification
+		query.Order("created_at desc").Limit(100).Find(&notifications)
+		c.JSON(http.StatusOK, notifications)
+	})
+
+	// 标记为已读，不指定 ids 时全部标记
+	r.POST("/api/notifications/read", authMiddleware, func(c *gin.Context) {
+		var req struct {
+			IDs []uint `json:"ids"`
+		}
+		c.ShouldBindJSON(&req)
+		query := db.Model(&Notification{}).Where("username = ?", c.MustGet("username").(string))
+		if len(req.IDs) > 0 {
+			query = query.Where("id IN ?", req.IDs)
+		}
+		query.Update("read", true)
+		c.JSON(http.StatusOK, gin.H{"message": "已标记为已读"})
+	})
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+const (
+	previewDir        = "./previews" // 文本预览缓存，与缩略图一样按内容哈希命名
+	previewMaxBytes   = 16 << 10     // 文本预览最多读取文件开头的字节数
+	previewMaxLines   = 60           // 文本预览的“第一页”行数
+	previewWorkers    = 2
+	previewRetryAfter = time.Hour // 生成失败后在这段时间内不再重试
+	previewMaxErrors  = 1000      // 最多记录的失败数，超出时清理过期记录
+)
+
+// 可以生成文本预览的扩展名及对应的语言
+var previewLanguages = map[string]string{
+	".txt": "text",
+	".md":  "markdown",
+	".cpp": "cpp", ".cc": "cpp", ".c": "cpp", ".h": "cpp", ".hpp": "cpp",
+	".py": "python",
+}
+
+// previewToken 带语法类型的一段文本：keyword, string, comment, number, heading, code, text
+type previewToken struct {
+	Type string `json:"t"`
+	Text string `json:"v"`
+}
+
+// textPreview 文本文件的预览，缓存为 JSON
+type textPreview struct {
+	Language  string           `json:"language"`
+	Content   string           `json:"content"`   // 第一页的原始文本
+	Lines     [][]previewToken `json:"lines"`     // 按行切分的语法片段
+	Truncated bool             `json:"truncated"` // 文件是否比预览更长
+}
+
+// 文本预览按内容和语言缓存：相同内容以不同扩展名保存时高亮规则不同
+func previewPath(hash, language string) string {
+	return filepath.Join(previewDir, hash+"."+language+".json")
+}
+
+// 删除内容对应的所有文本预览
+func removePreviews(hash string) {
+	files, _ := filepath.Glob(filepath.Join(previewDir, hash+".*"))
+	for _, f := range files {
+		os.Remove(f)
+	}
+}
+
+func previewLanguage(name string) string {
+	return previewLanguages[strings.ToLower(path.Ext(name))]
+}
+
+// 判断文件支持哪种预览：image、text，不支持时返回空
+func previewKind(name, mimeType string) string {
+	if thumbnailTypes[mimeType] {
+		return "image"
+	}
+	if previewLanguage(name) != "" {
+		return "text"
+	}
+	return ""
+}
+
+// 后台生成预览的任务队列，同一预览同时只排队一次；生成失败的记录下来，一段时间内不再重复尝试
+type previewJob struct {
+	Hash string
+	Name string
+	MIME string
+}
+
+// 排队和失败记录的键：图片只与内容有关，文本还与语言有关
+func (job previewJob) key() string {
+	if previewKind(job.Name, job.MIME) == "text" {
+		return job.Hash + "." + previewLanguage(job.Name)
+	}
+	return job.Hash
+}
+
+var (
+	previewQueue   = make(chan previewJob, 256)
+	previewPending = map[string]bool{}
+	previewErrors  = map[string]time.Time{} // 生成失败的时间
+	previewMu      sync.Mutex
+)
+
+// 提交预览生成任务，已有缓存、不支持预览或已在排队时直接返回
+func queuePreview(hash, name, mimeType string) {
+	job := previewJob{Hash: hash, Name: name, MIME: mimeType}
+	if previewKind(name, mimeType) == "" || previewReady(hash, name, mimeType) {
+		return
+	}
+	previewMu.Lock()
+	defer previewMu.Unlock()
+	if previewPending[job.key()] || recentlyFailed(job.key()) {
+		return
+	}
+	select {
+	case previewQueue <- job:
+		previewPending[job.key()] = true
+	default:
+		// 队列已满时放弃，下次请求预览时会重新排队
+	}
+}
+
+func previewReady(hash, name, mimeType string) bool {
+	p := previewPath(hash, previewLanguage(name))
+	if previewKind(name, mimeType) == "image" {
+		p = thumbnailPath(hash)
+	}
+	_, err := os.Stat(p)
+	return err == nil
+}
+
+func previewFailed(hash, name, mimeType string) bool {
+	previewMu.Lock()
+	defer previewMu.Unlock()
+	return recentlyFailed(previewJob{Hash: hash, Name: name, MIME: mimeType}.key())
+}
+
+// 调用方需持有 previewMu
+func recentlyFailed(key string) bool {
+	failedAt, ok := previewErrors[key]
+	return ok && time.Since(failedAt) < previewRetryAfter
+}
+
+// 记录生成失败，记录过多时先清理过期的，仍然过多则全部清空。调用方需持有 previewMu
+func recordPreviewError(key string) {
+	if len(previewErrors) >= previewMaxErrors {
+		for k := range previewErrors {
+			if !recentlyFailed(k) {
+				delete(previewErrors, k)
+			}
+		}
+		if len(previewErrors) >= previewMaxErrors {
+			previewErrors = map[string]time.Time{}
+		}
+	}
+	previewErrors[key] = time.Now()
+}
+
+func startPreviewWorkers() {
+	os.MkdirAll(previewDir, os.ModePerm)
+	for i := 0; i < previewWorkers; i++ {
+		go func() {
+			for job := range previewQueue {
+				err := generatePreview(job)
+				if err != nil {
+					log.Printf("生成预览失败 %s: %v", job.Name, err)
+				}
+				previewMu.Lock()
+				delete(previewPending, job.key())
+				if err != nil {
+					recordPreviewError(job.key())
+				}
+				previewMu.Unlock()
+			}
+		}()
+	}
+}
+
+func generatePreview(job previewJob) error {
+	switch previewKind(job.Name, job.MIME) {
+	case "image":
+		_, err := ensureThumbnail(job.Hash, job.MIME)
+		return err
+	case "text":
+		language := previewLanguage(job.Name)
+		preview, err := buildTextPreview(blobPath(job.Hash), language)
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(preview)
+		if err != nil {
+			return err
+		}
+		tmp := previewPath(job.Hash, language) + ".tmp"
+		if err := os.WriteFile(tmp, data, 0644); err != nil {
+			return err
+		}
+		return os.Rename(tmp, previewPath(job.Hash, language))
+	}
+	return nil
+}
+
+// 读取缓存的文本预览
+func loadTextPreview(hash, language string) (*textPreview, error) {
+	data, err := os.ReadFile(previewPath(hash, language))
+	if err != nil {
+		return nil, err
+	}
+	var preview textPreview
+	if err := json.Unmarshal(data, &preview); err != nil {
+		return nil, err
+	}
+	return &preview, nil
+}
+
+// 读取文件开头生成预览，非 UTF-8 的文本按 GB18030 解码
+func buildTextPreview(file, language string) (*textPreview, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, previewMaxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	truncated := len(data) > previewMaxBytes
+	if truncated {
+		data = data[:previewMaxBytes]
+		// 不要从多字节字符中间截断
+		for i := 0; i < utf8.UTFMax && len(data) > 0 && !utf8.Valid(data); i++ {
+			data = data[:len(data)-1]
+		}
+	}
+	text, err := decodeText(data)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	if len(lines) > previewMaxLines {
+		lines = lines[:previewMaxLines]
+		truncated = true
+	}
+	return &textPreview{
+		Language:  language,
+		Content:   strings.Join(lines, "\n"),
+		Lines:     highlightLines(lines, language),
+		Truncated: truncated,
+	}, nil
+}
+
+// 将文件内容解码为文本：非 UTF-8 的按 GB18030 解码，去掉 BOM 并统一换行符；包含 NUL 时认为不是文本
+func decodeText(data []byte) (string, error) {
+	if bytes.IndexByte(data, 0) >= 0 {
+		return "", fmt.Errorf("不是文本文件")
+	}
+	text := string(data)
+	if !utf8.ValidString(text) {
+		decoded, err := simplifiedchinese.GB18030.NewDecoder().String(text)
+		if err != nil {
+			return "", fmt.Errorf("无法识别文本编码")
+		}
+		text = decoded
+	}
+	return strings.ReplaceAll(strings.TrimPrefix(text, "\ufeff"), "\r\n", "\n"), nil
+}
+
+// 各语言的词法规则，足够区分关键字、字符串、注释和数字
+type syntaxRules struct {
+	keywords     map[string]bool
+	lineComment  string
+	blockComment [2]string
+	tripleQuotes bool // Python 的三引号字符串可以跨行
+}
+
+func wordSet(words string) map[string]bool {
+	set := map[string]bool{}
+	for _, w := range strings.Fields(words) {
+		set[w] = true
+	}
+	return set
+}
+
+var syntaxByLanguage = map[string]*syntaxRules{
+	"cpp": {
+		keywords: wordSet(`auto bool break case catch char class const constexpr continue default delete do double else enum
+			explicit extern false float for friend goto if inline int long namespace new nullptr operator private protected
+			public return short signed sizeof static struct switch template this throw true try typedef typename union
+			unsigned using virtual void volatile while #include #define #ifdef #ifndef #endif #pragma`),
+		lineComment:  "//",
+		blockComment: [2]string{"/*", "*/"},
+	},
+	"python": {
+		keywords: wordSet(`False None True and as assert async await break class continue def del elif else except finally
+			for from global if import in is lambda nonlocal not or pass raise return try while with yield self`),
+		lineComment:  "#",
+		tripleQuotes: true,
+	},
+}
+
+// 按行切分语法片段。多行注释和三引号字符串的状态会延续到下一行
+func highlightLines(lines []string, language string) [][]previewToken {
+	result := make([][]previewToken, 0, len(lines))
+	if language == "markdown" {
+		inCode := false
+		for _, line := range lines {
+			trimmed := strings.TrimSpace(line)
+			tokenType := "text"
+			switch {
+			case strings.HasPrefix(trimmed, "```"):
+				inCode = !inCode
+				tokenType = "code"
+			case inCode:
+				tokenType = "code"
+			case strings.HasPrefix(trimmed, "#"):
+				tokenType = "heading"
+			}
+			result = append(result, []previewToken{{Type: tokenType, Text: line}})
+		}
+		return result
+	}
+
+	rules := syntaxByLanguage[language]
+	if rules == nil {
+		for _, line := range lines {
+			result = append(result, []previewToken{{Type: "text", Text: line}})
+		}
+		return result
+	}
+
+	open := "" // 跨行未结束的注释或字符串的结束标记
+	for _, line := range lines {
+		var tokens []previewToken
+		emit := func(t, s string) {
+			if s == "" {
+				return
+			}
+			if n := len(tokens); n > 0 && tokens[n-1].Type == t {
+				tokens[n-1].Text += s
+				return
+			}
+			tokens = append(tokens, previewToken{Type: t, Text: s})
+		}
+
+		rest := line
+		for rest != "" {
+			if open != "" {
+				tokenType := "comment"
+				if open != rules.blockComment[1] {
+					tokenType = "string"
+				}
+				end := strings.Index(rest, open)
+				if end < 0 {
+					emit(tokenType, rest)
+					rest = ""
+					break
+				}
+				emit(tokenType, rest[:end+len(open)])
+				rest = rest[end+len(open):]
+				open = ""
+				continue
+			}
+
+			switch {
+			case rules.lineComment != "" && strings.HasPrefix(rest, rules.lineComment):
+				emit("comment", rest)
+				rest = ""
+			case rules.blockComment[0] != "" && strings.HasPrefix(rest, rules.blockComment[0]):
+				emit("comment", rules.blockComment[0])
+				rest = rest[len(rules.blockComment[0]):]
+				open = rules.blockComment[1]
+			case rules.tripleQuotes && (strings.HasPrefix(rest, `"""`) || strings.HasPrefix(rest, `'''`)):
+				emit("string", rest[:3])
+				open = rest[:3]
+				rest = rest[3:]
+			case rest[0] == '"' || rest[0] == '\'':
+				end := closingQuote(rest)
+				emit("string", rest[:end])
+				rest = rest[end:]
+			default:
+				r, size := utf8.DecodeRuneInString(rest)
+				if isWordRune(r) || r == '#' {
+					end := size
+					for end < len(rest) {
+						r2, s2 := utf8.DecodeRuneInString(rest[end:])
+						// 数字可以包含小数点，如 3.14
+						if !isWordRune(r2) && !(r2 == '.' && unicode.IsDigit(r)) {
+							break
+						}
+						end += s2
+					}
+					word := rest[:end]
+					switch {
+					case rules.keywords[word]:
+						emit("keyword", word)
+					case unicode.IsDigit(r):
+						emit("number", word)
+					default:
+						emit("text", word)
+					}
+					rest = rest[end:]
+				} else {
+					emit("text", rest[:size])
+					rest = rest[size:]
+				}
+			}
+		}
+		if tokens == nil {
+			tokens = []previewToken{}
+		}
+		result = append(result, tokens)
+	}
+	return result
+}
+
+// 返回以引号开头的字符串字面量的长度（含结束引号），没有结束引号时到行尾
+func closingQuote(s string) int {
+	quote := s[0]
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case quote:
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 个人资料各字段的名称和长度上限（按字符计）
+var profileFields = map[string]struct {
+	Label  string
+	MaxLen int
+}{
+	"bio":         {"个人简介", 200},
+	"pronouns":    {"人称代词", 16},
+	"class_group": {"班级/小组", 32},
+	"contact":     {"联系方式", 64},
+}
+
+// 校验个人资料字段，简介允许换行，其余字段只允许单行文本
+func normalizeProfileField(field, value string) (string, error) {
+	spec := profileFields[field]
+	value = strings.TrimSpace(value)
+	if utf8.RuneCountInString(value) > spec.MaxLen {
+		return "", fmt.Errorf("%s不能超过%d个字符", spec.Label, spec.MaxLen)
+	}
+	for _, r := range value {
+		if r == '\n' && field == "bio" {
+			continue
+		}
+		if !unicode.IsPrint(r) || unicode.Is(unicode.Cf, r) {
+			return "", fmt.Errorf("%s包含不可见或控制字符", spec.Label)
+		}
+	}
+	return value, nil
+}
+
+// 统计用户分享的文件夹数量
+func countSharedFolders(username string) int {
+	prefix := username + "_"
+	count := 0
+	for _, e := range listSharedDir("") {
+		if e.IsDir && e.Owner == username && strings.HasPrefix(e.Name, prefix) {
+			count++
+		}
+	}
+	return count
+}
+
+// 生成对外公开的个人资料
+func publicProfile(user *User) gin.H {
+	return gin.H{
+		"username":            user.Username,
+		"display_name":        user.Name(),
+		"avatar":              user.Avatar,
+		"role":                user.Role,
+		"bio":                 user.Bio,
+		"pronouns":            user.Pronouns,
+		"class_group":         user.ClassGroup,
+		"contact":             user.Contact,
+		"joined_at":           user.CreatedAt,
+		"message_count":       user.MessageCount,
+		"shared_folder_count": countSharedFolders(user.Username),
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// 各角色默认存储配额（字节），0 表示不限，可通过 Config 的 quota_<role> 修改
+var defaultRoleQuotas = map[string]int64{
+	"user":   5 << 30,
+	"admin":  0,
+	"system": 0,
+}
+
+// 磁盘剩余空间低于该值时拒绝所有上传，可通过 Config 的 min_free_disk 修改
+const defaultMinFreeDisk = 1 << 30
+
+// 格式化字节数，用于错误提示
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// 统计用户已占用的空间：共享空间中该用户拥有的所有文件、聊天附件，以及回收站中的内容和历史版本，
+// 它们同样占用存储，直到被清除
+func userStorageUsage(username string) int64 {
+	var shared, attachments, trashed, versions int64
+	db.Model(&SharedItem{}).Where("owner = ?", username).
+		Select("COALESCE(SUM(size), 0)").Scan(&shared)
+	db.Model(&Attachment{}).Where("uploader = ?", username).
+		Select("COALESCE(SUM(size), 0)").Scan(&attachments)
+	db.Model(&TrashEntry{}).Where("owner = ?", username).
+		Select("COALESCE(SUM(size), 0)").Scan(&trashed)
+	db.Model(&FileVersion{}).Where("owner = ?", username).
+		Select("COALESCE(SUM(size), 0)").Scan(&versions)
+	return shared + attachments + trashed + versions
+}
+
+// 统计用户等待审核和正在分片上传的空间，完成后都会计入 shared
+func userPendingUsage(username string) int64 {
+	var pending, uploading int64
+	db.Model(&PendingUpload{}).Where("username = ? AND status = ?", username, "pending").
+		Select("COALESCE(SUM(total_size), 0)").Scan(&pending)
+	db.Model(&UploadSession{}).Where("username = ?", username).
+		Select("COALESCE(SUM(total_size), 0)").Scan(&uploading)
+	return pending + uploading
+}
+
+func roleQuota(role string) int64 {
+	return getConfigInt("quota_"+role, defaultRoleQuotas[role])
+}
+
+// 用户的有效配额：管理员单独设置的优先，否则按角色默认
+func userQuota(user *User) int64 {
+	if user.StorageQuota != nil {
+		return *user.StorageQuota
+	}
+	return roleQuota(user.Role)
+}
+
+// 检查上传 incoming 字节后是否会超出用户配额或磁盘保留空间，
+// 返回需要响应的 HTTP 状态码（与其他上传大小限制一致，统一为 413）和错误信息，可以上传时返回 nil
+func checkStorage(user *User, incoming int64) (int, error) {
+	if free, err := diskFreeBytes(blobDir); err == nil {
+		minFree := getConfigInt("min_free_disk", defaultMinFreeDisk)
+		if free-incoming < minFree {
+			return http.StatusRequestEntityTooLarge, fmt.Errorf("服务器磁盘空间不足，暂时无法上传")
+		}
+	}
+
+	quota := userQuota(user)
+	if quota <= 0 {
+		return 0, nil
+	}
+	used := userStorageUsage(user.Username) + userPendingUsage(user.Username)
+	if used+incoming > quota {
+		return http.StatusRequestEntityTooLarge, fmt.Errorf("存储空间不足：已用 %s（含待审核、回收站和历史版本），配额 %s，本次上传 %s",
+			formatBytes(used), formatBytes(quota), formatBytes(incoming))
+	}
+	return 0, nil
+}
@@ -0,0 +1,17 @@
+package main
+
+import "testing"
+
+// 回收站中的内容和历史版本在清除之前同样计入用量
+func TestUserStorageUsageCountsTrashAndVersions(t *testing.T) {
+	setupTestStorage(t)
+	db.Create(&SharedItem{Owner: "alice", Path: "alice_课件/a.txt", Size: 10})
+	db.Create(&FileVersion{Owner: "alice", Path: "alice_课件/a.txt", Size: 20})
+	db.Create(&TrashEntry{Owner: "alice", Path: "alice_课件/b.txt", Size: 40})
+	db.Create(&FileVersion{Owner: "alice", Path: "alice_课件/b.txt", Size: 80, TrashID: 1})
+	db.Create(&TrashEntry{Owner: "bob", Path: "bob_x", Size: 1000})
+
+	if used := userStorageUsage("alice"); used != 150 {
+		t.Fatalf("used = %d, want 150", used)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 注册模式，存储在 Config 的 registration_mode 中
+const (
+	RegistrationOpen     = "open"     // 开放注册
+	RegistrationInvite   = "invite"   // 仅限邀请码注册
+	RegistrationApproval = "approval" // 注册后需管理员审核
+	RegistrationClosed   = "closed"   // 关闭注册
+)
+
+// 同一IP在时间窗口内允许的注册尝试次数
+const (
+	registerRateLimit  = 5
+	registerRateWindow = 10 * time.Minute
+)
+
+func isValidRegistrationMode(mode string) bool {
+	switch mode {
+	case RegistrationOpen, RegistrationInvite, RegistrationApproval, RegistrationClosed:
+		return true
+	}
+	return false
+}
+
+// 获取当前注册模式
+func getRegistrationMode() string {
+	mode := getConfigValue("registration_mode", RegistrationOpen)
+	if !isValidRegistrationMode(mode) {
+		return RegistrationOpen
+	}
+	return mode
+}
+
+// 按IP统计注册尝试次数的简单滑动窗口限流器
+type ipRateLimiter struct {
+	mu       sync.Mutex
+	attempts map[string][]time.Time
+	limit    int
+	window   time.Duration
+}
+
+func newIPRateLimiter(limit int, window time.Duration) *ipRateLimiter {
+	return &ipRateLimiter{
+		attempts: make(map[string][]time.Time),
+		limit:    limit,
+		window:   window,
+	}
+}
+
+// 记录一次尝试，超过限制时返回 false
+func (l *ipRateLimiter) allow(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	recent := l.attempts[ip][:0]
+	for _, t := range l.attempts[ip] {
+		if now.Sub(t) < l.window {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= l.limit {
+		l.attempts[ip] = recent
+		return false
+	}
+	l.attempts[ip] = append(recent, now)
+
+	// 顺手清理长时间没有活动的IP，避免 map 无限增长
+	for key, times := range l.attempts {
+		if len(times) == 0 || now.Sub(times[len(times)-1]) >= l.window {
+			delete(l.attempts, key)
+		}
+	}
+	return true
+}
+
+// 判断是否已达到限制，不记录本次
+func (l *ipRateLimiter) exceeded(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	count := 0
+	for _, t := range l.attempts[ip] {
+		if time.Since(t) < l.window {
+			count++
+		}
+	}
+	return count >= l.limit
+}
+
+var registerLimiter = newIPRateLimiter(registerRateLimit, registerRateWindow)
+
+// 在事务中校验并消耗一次邀请码
+func useInviteCode(tx *gorm.DB, code string) error {
+	var invite InviteCode
+	if err := tx.Where("code = ?", code).First(&invite).Error; err != nil {
+		return fmt.Errorf("邀请码无效")
+	}
+	if invite.ExpiresAt != nil && time.Now().After(*invite.ExpiresAt) {
+		return fmt.Errorf("邀请码已过期")
+	}
+	if invite.MaxUses > 0 && invite.UsedCount >= invite.MaxUses {
+		return fmt.Errorf("邀请码使用次数已达上限")
+	}
+	// 带条件更新，防止并发注册超出使用次数
+	result := tx.Model(&InviteCode{}).
+		Where("code = ? AND (max_uses = 0 OR used_count < max_uses)", code).
+		Update("used_count", gorm.Expr("used_count + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("邀请码使用次数已达上限")
+	}
+	return nil
+}
+
+// 生成随机邀请码（去掉了易混淆的字符）
+func generateInviteCode() string {
+	const alphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	var sb strings.Builder
+	for _, b := range buf {
+		sb.WriteByte(alphabet[int(b)%len(alphabet)])
+	}
+	return sb.String()
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pendingFile 待审核上传中的一个文件
+type pendingFile struct {
+	Path    string    `json:"path"` // 相对于上传文件夹的路径
+	Size    int64     `json:"size"`
+	MIME    string    `json:"mime"`
+	ModTime time.Time `json:"modified_at"`
+}
+
+// reviewDecision 一次审核操作
+type reviewDecision struct {
+	Action     string    `json:"action"` // approved, rejected, quarantined（通过时未通过安全检查）
+	Files      []string  `json:"files"`
+	Reason     string    `json:"reason,omitempty"`
+	ReviewedBy string    `json:"reviewed_by"`
+	At         time.Time `json:"at"`
+}
+
+// 记录一次审核，Reason 和 ReviewedBy 保存最近一次的
+func recordReviewDecision(pending *PendingUpload, action string, files []string, reason, reviewer string) {
+	pending.Decisions = append(pending.Decisions, reviewDecision{Action: action, Files: files, Reason: reason, ReviewedBy: reviewer, At: time.Now()})
+	pending.Reason = reason
+	pending.ReviewedBy = reviewer
+}
+
+// 同一个待审核上传同时只能有一个审核或过期操作，否则两个管理员可能重复导入同一批文件。按 ID 分段加锁
+var pendingLocks [16]sync.Mutex
+
+func lockPendingUpload(id uint) func() {
+	m := &pendingLocks[id%uint(len(pendingLocks))]
+	m.Lock()
+	return m.Unlock
+}
+
+// 上传通过后在共享空间中的目录。单文件上传记录为 uploads/<文件名>，放到 <username>_uploads 下；
+// 文件夹上传对应 <username>_<文件夹名>
+func pendingLogicalDir(pending *PendingUpload) string {
+	if strings.HasPrefix(pending.FolderName, "uploads/") {
+		return pending.Username + "_uploads"
+	}
+	return fmt.Sprintf("%s_%s", pending.Username, pending.FolderName)
+}
+
+// 审核结果通知中显示的名称
+func pendingDisplayName(pending *PendingUpload) string {
+	return strings.TrimPrefix(pending.FolderName, "uploads/")
+}
+
+// 列出临时目录中还没有处理的文件
+func listPendingFiles(pending *PendingUpload) []pendingFile {
+	files := []pendingFile{}
+	filepath.WalkDir(pending.TempPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(pending.TempPath, path)
+		if err != nil {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		files = append(files, pendingFile{
+			Path:    filepath.ToSlash(rel),
+			Size:    info.Size(),
+			MIME:    detectMIME(path, rel),
+			ModTime: info.ModTime(),
+		})
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files
+}
+
+// 从待处理文件中选出要审核的文件，paths 为空时选择全部
+func selectPendingFiles(pending *PendingUpload, paths []string) ([]pendingFile, error) {
+	files := listPendingFiles(pending)
+	if len(paths) == 0 {
+		return files, nil
+	}
+	byPath := map[string]pendingFile{}
+	for _, f := range files {
+		byPath[f.Path] = f
+	}
+	var selected []pendingFile
+	for _, p := range paths {
+		rel, err := cleanRelPath(p)
+		if err != nil {
+			return nil, fmt.Errorf("文件路径无效: %s", p)
+		}
+		f, ok := byPath[rel]
+		if !ok {
+			return nil, fmt.Errorf("文件不存在或已处理: %s", p)
+		}
+		selected = append(selected, f)
+	}
+	return selected, nil
+}
+
+// 检查文件能否以 username 的身份导入到 dst：目标目录仍属于该用户（等待期间可能注册了用户名与之冲突的用户），
+// 上级路径上也没有同名文件
+func checkImportTarget(username, dst string) error {
+	if err := checkUploadTarget(username, dst); err != nil {
+		return err
+	}
+	for p := sharedParent(dst); p != ""; p = sharedParent(p) {
+		if _, err := findSharedFile(p); err == nil {
+			return fmt.Errorf("目标位置 %s 是一个文件", p)
+		}
+	}
+	return nil
+}
+
+// 将一个待审核文件导入共享空间，返回最终的逻辑路径。同名文件默认覆盖（旧内容保留为历史版本），
+// conflict 为 rename 时自动改名；目标位置是目录时总是改名
+func approvePendingFile(pending *PendingUpload, rel, conflict string) (string, error) {
+	src, err := safeJoin(pending.TempPath, rel)
+	if err != nil {
+		return "", err
+	}
+	dst := joinSharedPath(pendingLogicalDir(pending), rel)
+	if err := checkImportTarget(pending.Username, dst); err != nil {
+		return "", err
+	}
+	if isSharedDir(dst) || (conflict == ConflictRename && sharedPathExists(dst)) {
+		dst = uniqueSharedPath(dst, true)
+	}
+	// 策略或病毒库可能在等待审核期间更新，通过前再检查一次
+	if q, err := scanUpload(src, rel, dst, pending.Username); err != nil {
+		return "", err
+	} else if q != nil {
+		return "", fmt.Errorf("%w: %s", errQuarantined, q.Reason)
+	}
+	if _, err := putSharedFile(src, dst, pending.Username); err != nil {
+		return "", err
+	}
+	return dst, nil
+}
+
+// 拒绝待审核的文件：启用回收站时移入回收站（保留相对路径，恢复后重新进入待审核），否则直接删除
+func trashPendingFiles(pending *PendingUpload, files []pendingFile, deletedBy string) error {
+	if !trashEnabled() {
+		for _, f := range files {
+			if src, err := safeJoin(pending.TempPath, f.Path); err == nil {
+				os.Remove(src)
+			}
+		}
+		return nil
+	}
+	dst := filepath.Join(trashDir, fmt.Sprintf("upload-%d-%d", pending.ID, time.Now().UnixNano()))
+	entry := TrashEntry{
+		Kind:        "upload",
+		Owner:       pending.Username,
+		Path:        pending.FolderName,
+		DeletedBy:   deletedBy,
+		Files:       []trashedFile{},
+		Folders:     []string{},
+		UploadID:    pending.ID,
+		StoragePath: dst,
+	}
+	for _, f := range files {
+		src, err := safeJoin(pending.TempPath, f.Path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, filepath.FromSlash(f.Path))
+		os.MkdirAll(filepath.Dir(target), os.ModePerm)
+		if err := os.Rename(src, target); err != nil {
+			return err
+		}
+		entry.Size += f.Size
+		entry.Files = append(entry.Files, trashedFile{Path: f.Path, Owner: pending.Username, Size: f.Size, MIME: f.MIME, CreatedAt: f.ModTime})
+	}
+	return db.Create(&entry).Error
+}
+
+// 把 src 目录中的文件移动到 dst 下的相同位置
+func mergeDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		os.MkdirAll(filepath.Dir(target), os.ModePerm)
+		return os.Rename(path, target)
+	})
+}
+
+// 审核一部分文件后更新记录：全部处理完时确定最终状态并删除临时目录
+func finishPendingReview(pending *PendingUpload) {
+	remaining := listPendingFiles(pending)
+	var total int64
+	for _, f := range remaining {
+		total += f.Size
+	}
+	pending.TotalSize = total
+	if len(remaining) == 0 {
+		switch {
+		case len(pending.RejectedFiles) == 0:
+			pending.Status = "approved"
+		case len(pending.ApprovedFiles) == 0:
+			pending.Status = "rejected"
+		default:
+			pending.Status = "partial"
+		}
+		os.RemoveAll(pending.TempPath)
+	} else {
+		removeEmptyDirs(pending.TempPath)
+		os.MkdirAll(pending.TempPath, os.ModePerm)
+	}
+	db.Save(pending)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// 文件名中不允许出现的字符（Windows 下有特殊含义，统一禁止以保证跨平台一致）
+const reservedPathChars = `<>:"|?*`
+
+// Windows 保留设备名，带任意扩展名同样无效（如 CON.txt）
+var reservedPathNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// 检查单个文件名或目录名
+func cleanPathSegment(name string) (string, error) {
+	switch {
+	case name == "":
+		return "", fmt.Errorf("名称不能为空")
+	case name == "." || name == "..":
+		return "", fmt.Errorf("不能使用 %s 作为名称", name)
+	case len(name) > 255:
+		return "", fmt.Errorf("名称过长")
+	case !utf8.ValidString(name):
+		return "", fmt.Errorf("名称编码无效")
+	case strings.ContainsAny(name, "/\\"):
+		return "", fmt.Errorf("名称不能包含路径分隔符")
+	}
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(reservedPathChars, r) {
+			return "", fmt.Errorf("名称包含非法字符")
+		}
+	}
+	// Windows 会静默去掉结尾的点和空格，导致两个名称指向同一个文件
+	if strings.HasSuffix(name, ".") || strings.HasSuffix(name, " ") {
+		return "", fmt.Errorf("名称不能以点或空格结尾")
+	}
+	base, _, _ := strings.Cut(strings.ToUpper(name), ".")
+	if reservedPathNames[strings.TrimRight(base, " ")] {
+		return "", fmt.Errorf("%s 是系统保留名称", name)
+	}
+	return name, nil
+}
+
+// 将用户提交的相对路径规范化为以 / 分隔的形式，空路径返回 ""（根目录）。
+// 拒绝绝对路径、盘符、.. 以及任何一段不合法的名称，而不是静默删除这些部分
+func cleanRelPath(p string) (string, error) {
+	if strings.ContainsRune(p, 0) {
+		return "", fmt.Errorf("路径包含非法字符")
+	}
+	p = strings.ReplaceAll(p, "\\", "/")
+	if strings.HasPrefix(p, "/") || filepath.VolumeName(p) != "" || (len(p) >= 2 && p[1] == ':') {
+		return "", fmt.Errorf("不允许使用绝对路径")
+	}
+	var parts []string
+	for _, seg := range strings.Split(p, "/") {
+		// 连续的分隔符和 . 不改变含义，直接忽略
+		if seg == "" || seg == "." {
+			continue
+		}
+		if _, err := cleanPathSegment(seg); err != nil {
+			return "", err
+		}
+		parts = append(parts, seg)
+	}
+	return strings.Join(parts, "/"), nil
+}
+
+// 解析共享空间中的逻辑路径，所有文件接口都通过它处理用户传入的路径
+func resolveSharedPath(p string) (string, error) {
+	return cleanRelPath(p)
+}
+
+// 将相对路径安全地拼接到本地目录 root 下，用于写入临时目录等真实文件系统操作。
+// 除了检查路径本身，还会解析已存在部分的符号链接，确保最终位置仍在 root 内
+func safeJoin(root, rel string) (string, error) {
+	rel, err := cleanRelPath(rel)
+	if err != nil {
+		return "", err
+	}
+	if rel == "" {
+		return "", fmt.Errorf("路径不能为空")
+	}
+	full := filepath.Join(root, filepath.FromSlash(rel))
+
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	// 找到已存在的最深一级，解析符号链接后判断是否逃出 root
+	existing, rest := full, ""
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return "", fmt.Errorf("路径无效")
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = parent
+	}
+	realExisting, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", fmt.Errorf("路径无效")
+	}
+	if !withinDir(realRoot, filepath.Join(realExisting, rest)) {
+		return "", fmt.Errorf("路径超出允许的目录")
+	}
+	return full, nil
+}
+
+// 判断 target 是否位于 dir 内（含 dir 本身）
+func withinDir(dir, target string) bool {
+	rel, err := filepath.Rel(dir, target)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var pathSeeds = []string{
+	"",
+	"a",
+	"a/b/c.txt",
+	"../etc/passwd",
+	"a/../../b",
+	"..\\..\\windows\\system32",
+	"/etc/passwd",
+	"\\\\server\\share\\x",
+	"C:\\Windows",
+	"c:foo",
+	"a/./b//c",
+	"a\x00b",
+	"CON",
+	"aux.txt",
+	"name. ",
+	"..",
+	"....//....//",
+	"%2e%2e/x",
+	"link/escape.txt",
+	"link/../x",
+	"中文/文件.txt",
+}
+
+// 校验通过的路径必须是规范的相对路径，拼接到 shared 下不会越界
+func FuzzResolveSharedPath(f *testing.F) {
+	for _, s := range pathSeeds {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, p string) {
+		clean, err := resolveSharedPath(p)
+		if err != nil {
+			return
+		}
+		if strings.ContainsRune(clean, 0) || strings.Contains(clean, "\\") {
+			t.Fatalf("%q -> %q 包含非法字符", p, clean)
+		}
+		if strings.HasPrefix(clean, "/") || filepath.IsAbs(clean) {
+			t.Fatalf("%q -> %q 是绝对路径", p, clean)
+		}
+		for _, seg := range strings.Split(clean, "/") {
+			if clean != "" && (seg == "" || seg == "." || seg == "..") {
+				t.Fatalf("%q -> %q 包含非法分段 %q", p, clean, seg)
+			}
+		}
+		root, _ := filepath.Abs(sharedDir)
+		if !withinDir(root, filepath.Join(root, filepath.FromSlash(clean))) {
+			t.Fatalf("%q -> %q 超出 shared 目录", p, clean)
+		}
+		// 规范化结果应保持稳定
+		if again, err := resolveSharedPath(clean); err != nil || again != clean {
+			t.Fatalf("%q -> %q 再次解析得到 %q, %v", p, clean, again, err)
+		}
+	})
+}
+
+// 在包含指向外部的符号链接的目录中拼接任意路径，结果都必须留在根目录内
+func FuzzSafeJoin(f *testing.F) {
+	for _, s := range pathSeeds {
+		f.Add(s)
+	}
+	base := f.TempDir()
+	root := filepath.Join(base, "shared")
+	outside := filepath.Join(base, "outside")
+	os.MkdirAll(filepath.Join(root, "a", "b"), 0o755)
+	os.MkdirAll(outside, 0o755)
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		f.Skip("当前系统不支持符号链接")
+	}
+	realRoot, _ := filepath.EvalSymlinks(root)
+
+	f.Fuzz(func(t *testing.T, p string) {
+		full, err := safeJoin(root, p)
+		if err != nil {
+			return
+		}
+		if !withinDir(root, full) || full == root {
+			t.Fatalf("%q -> %q 超出根目录", p, full)
+		}
+		// 解析已存在部分的符号链接后仍应在根目录内
+		existing, rest := full, ""
+		for {
+			if _, err := os.Lstat(existing); err == nil {
+				break
+			}
+			rest = filepath.Join(filepath.Base(existing), rest)
+			existing = filepath.Dir(existing)
+		}
+		real, err := filepath.EvalSymlinks(existing)
+		if err != nil {
+			t.Fatalf("%q: %v", p, err)
+		}
+		if !withinDir(realRoot, filepath.Join(real, rest)) {
+			t.Fatalf("%q -> %q 通过符号链接逃出根目录", p, full)
+		}
+	})
+}
+
+func TestSafeJoinRejectsSymlinkEscape(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "shared")
+	os.MkdirAll(root, 0o755)
+	if err := os.Symlink(base, filepath.Join(root, "link")); err != nil {
+		t.Skip("当前系统不支持符号链接")
+	}
+	for _, p := range []string{"link/x.txt", "link", "link/shared/../x"} {
+		if full, err := safeJoin(root, p); err == nil {
+			t.Errorf("safeJoin(%q) = %q, 应当拒绝", p, full)
+		}
+	}
+	if _, err := safeJoin(root, "ok/x.txt"); err != nil {
+		t.Errorf("safeJoin(ok/x.txt) 不应出错: %v", err)
+	}
+}
+
+func TestCleanRelPath(t *testing.T) {
+	valid := map[string]string{
+		"":             "",
+		"a/b":          "a/b",
+		"a\\b":         "a/b",
+		"a//./b/":      "a/b",
+		"中文 文件夹/x.txt": "中文 文件夹/x.txt",
+		"v1.2/readme":  "v1.2/readme",
+	}
+	for in, want := range valid {
+		if got, err := cleanRelPath(in); err != nil || got != want {
+			t.Errorf("cleanRelPath(%q) = %q, %v; 期望 %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{
+		"..", "a/../b", "/abs", "\\abs", "C:\\x", "c:x", "a\x00b", "CON", "con.txt",
+		"a/LPT1/b", "trail.", "trail ", "a\x01", "a:b", "a|b",
+	} {
+		if got, err := cleanRelPath(in); err == nil {
+			t.Errorf("cleanRelPath(%q) = %q, 应当拒绝", in, got)
+		}
+	}
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 上传文件的安全检查。每个上传的文件在导入共享空间或进入待审核前、以及管理员通过审核前都会检查一次，
+// 未通过的文件移入隔离区，由管理员决定放行或删除
+const quarantineDir = "./quarantine"
+
+var errQuarantined = errors.New("未通过安全检查，已隔离")
+
+// 文件未通过安全检查，但移入隔离区失败
+var errQuarantineFailed = errors.New("文件未通过安全检查，隔离失败，已取消上传")
+
+// uploadScanner 文件检查器，返回非空的原因表示文件不安全
+type uploadScanner interface {
+	Name() string
+	Scan(file, name string) (string, error)
+}
+
+// 通过 registerScanner 添加的额外检查器
+var extraScanners []uploadScanner
+
+func registerScanner(s uploadScanner) {
+	extraScanners = append(extraScanners, s)
+}
+
+// ScannerSettings 安全检查设置，以 JSON 存储在 Config 的 scanner_settings 中
+type ScannerSettings struct {
+	BlockExecutables bool     `json:"block_executables"` // 拦截可执行文件（按扩展名和文件头判断）
+	BlockScripts     bool     `json:"block_scripts"`     // 拦截脚本文件
+	ScriptExtensions []string `json:"script_extensions"` // 视为脚本的扩展名
+	ClamdAddress     string   `json:"clamd_address"`     // ClamAV clamd 地址，如 /var/run/clamav/clamd.ctl 或 tcp:127.0.0.1:3310，为空时不使用
+}
+
+var defaultScannerSettings = ScannerSettings{
+	BlockExecutables: true,
+	BlockScripts:     true,
+	ScriptExtensions: []string{".bat", ".cmd", ".ps1", ".psm1", ".vbs", ".vbe", ".wsf", ".wsh", ".hta", ".lnk", ".reg"},
+}
+
+func getScannerSettings() ScannerSettings {
+	settings := defaultScannerSettings
+	if raw := getConfigValue("scanner_settings", ""); raw != "" {
+		json.Unmarshal([]byte(raw), &settings)
+	}
+	return settings
+}
+
+func setScannerSettings(settings ScannerSettings) error {
+	settings.ScriptExtensions = normalizeExtensions(settings.ScriptExtensions)
+	settings.ClamdAddress = strings.TrimSpace(settings.ClamdAddress)
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	return setConfigValue("scanner_settings", string(data))
+}
+
+// 按当前设置启用的检查器
+func activeScanners() []uploadScanner {
+	settings := getScannerSettings()
+	scanners := []uploadScanner{magicScanner{}}
+	if settings.BlockExecutables || settings.BlockScripts {
+		scanners = append(scanners, policyScanner{settings})
+	}
+	if settings.ClamdAddress != "" {
+		scanners = append(scanners, clamdScanner{settings.ClamdAddress})
+	}
+	return append(scanners, extraScanners...)
+}
+
+// 读取文件开头用于判断格式
+func readFileHeader(file string, n int) ([]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	buf := make([]byte, n)
+	k, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	return buf[:k], nil
+}
+
+// 常见格式的文件头，扩展名在表中时内容必须以其中之一开头
+var magicSignatures = map[string][]string{
+	".png":  {"\x89PNG\r\n\x1a\n"},
+	".jpg":  {"\xff\xd8\xff"},
+	".jpeg": {"\xff\xd8\xff"},
+	".gif":  {"GIF87a", "GIF89a"},
+	".bmp":  {"BM"},
+	".pdf":  {"%PDF-"},
+	".zip":  {"PK\x03\x04", "PK\x05\x06"},
+	".docx": {"PK\x03\x04"},
+	".xlsx": {"PK\x03\x04"},
+	".pptx": {"PK\x03\x04"},
+	".doc":  {"\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1"},
+	".xls":  {"\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1"},
+	".ppt":  {"\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1"},
+	".gz":   {"\x1f\x8b"},
+	".7z":   {"7z\xbc\xaf\x27\x1c"},
+	".rar":  {"Rar!\x1a\x07"},
+}
+
+// 可执行文件的文件头：Windows PE、Linux ELF、macOS Mach-O
+var executableSignatures = []string{"MZ", "\x7fELF", "\xfe\xed\xfa\xce", "\xfe\xed\xfa\xcf", "\xce\xfa\xed\xfe", "\xcf\xfa\xed\xfe"}
+
+var executableExtensions = map[string]bool{
+	".exe": true, ".dll": true, ".msi": true, ".com": true, ".scr": true, ".cpl": true, ".sys": true,
+	".elf": true, ".so": true, ".dylib": true, ".app": true, ".pif": true,
+}
+
+func hasAnyPrefix(data []byte, prefixes []string) bool {
+	for _, p := range prefixes {
+		if bytes.HasPrefix(data, []byte(p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// magicScanner 检查内容与扩展名是否一致，防止把可执行文件改名为图片、文档上传
+type magicScanner struct{}
+
+func (magicScanner) Name() string { return "magic" }
+
+func (magicScanner) Scan(file, name string) (string, error) {
+	ext := strings.ToLower(path.Ext(name))
+	signatures, ok := magicSignatures[ext]
+	if !ok {
+		return "", nil
+	}
+	header, err := readFileHeader(file, 16)
+	if err != nil {
+		return "", err
+	}
+	if len(header) == 0 || hasAnyPrefix(header, signatures) {
+		return "", nil
+	}
+	if hasAnyPrefix(header, executableSignatures) {
+		return fmt.Sprintf("扩展名为 %s，但内容是可执行文件", ext), nil
+	}
+	return fmt.Sprintf("扩展名为 %s，但内容不是该格式", ext), nil
+}
+
+// policyScanner 按设置拦截可执行文件和脚本
+type policyScanner struct {
+	settings ScannerSettings
+}
+
+func (policyScanner) Name() string { return "policy" }
+
+func (s policyScanner) Scan(file, name string) (string, error) {
+	ext := strings.ToLower(path.Ext(name))
+	if s.settings.BlockExecutables && executableExtensions[ext] {
+		return "不允许上传可执行文件 (" + ext + ")", nil
+	}
+	if s.settings.BlockScripts && containsString(normalizeExtensions(s.settings.ScriptExtensions), ext) {
+		return "不允许上传脚本文件 (" + ext + ")", nil
+	}
+	header, err := readFileHeader(file, 16)
+	if err != nil {
+		return "", err
+	}
+	if s.settings.BlockExecutables && hasAnyPrefix(header, executableSignatures) {
+		return "不允许上传可执行文件", nil
+	}
+	// 带 #! 的脚本，常见源码文件（如 .py）除外
+	if _, isSource := previewLanguages[ext]; s.settings.BlockScripts && !isSource && bytes.HasPrefix(header, []byte("#!")) {
+		return "不允许上传可执行脚本", nil
+	}
+	return "", nil
+}
+
+// clamdScanner 通过 clamd 的 INSTREAM 命令扫描病毒
+type clamdScanner struct {
+	address string
+}
+
+func (clamdScanner) Name() string { return "clamav" }
+
+func (s clamdScanner) Scan(file, name string) (string, error) {
+	network, address := "unix", s.address
+	if rest, ok := strings.CutPrefix(address, "tcp:"); ok {
+		network, address = "tcp", rest
+	} else {
+		address = strings.TrimPrefix(address, "unix:")
+	}
+	conn, err := net.DialTimeout(network, address, 5*time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Minute))
+
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return "", err
+	}
+	// 数据分块发送，每块前加 4 字节大端长度，长度为 0 表示结束
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			if err := binary.Write(conn, binary.BigEndian, uint32(n)); err != nil {
+				return "", err
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return "", err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	if err := binary.Write(conn, binary.BigEndian, uint32(0)); err != nil {
+		return "", err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return "", err
+	}
+	reply = strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+	// 回复格式：stream: OK 或 stream: <病毒名> FOUND
+	switch {
+	case strings.HasSuffix(reply, " FOUND"):
+		return "检测到病毒: " + strings.TrimSuffix(strings.TrimPrefix(reply, "stream: "), " FOUND"), nil
+	case strings.HasSuffix(reply, "OK"):
+		return "", nil
+	}
+	return "", fmt.Errorf("clamd 返回: %s", reply)
+}
+
+// 检查一个本地文件，不安全时移入隔离区并返回隔离记录。destination 为通过后应放入的逻辑路径，
+// 聊天附件等没有固定位置的文件为空。检查器出错时记录日志并放行，避免 clamd 不可用时无法上传；
+// 文件不安全但隔离失败时返回错误，文件留在原处，由调用方让本次上传失败
+func scanUpload(file, name, destination, username string) (*QuarantineItem, error) {
+	for _, scanner := range activeScanners() {
+		reason, err := scanner.Scan(file, name)
+		if err != nil {
+			log.Printf("文件检查失败 (%s) %s: %v", scanner.Name(), name, err)
+			continue
+		}
+		if reason == "" {
+			continue
+		}
+		item, err := quarantineFile(file, name, destination, username, scanner.Name(), reason)
+		if err != nil {
+			log.Printf("隔离文件失败 %s: %v", name, err)
+			return nil, errQuarantineFailed
+		}
+		log.Printf("文件 %s（%s 上传）未通过检查，已隔离: %s", name, username, reason)
+		return item, nil
+	}
+	return nil, nil
+}
+
+// 按新名称重新检查已保存的文件，不通过时返回原因但不隔离，用于重命名这类只改变名称的操作
+func rescanRenamedFile(file, name string) string {
+	for _, scanner := range activeScanners() {
+		reason, err := scanner.Scan(file, name)
+		if err != nil {
+			log.Printf("文件检查失败 (%s) %s: %v", scanner.Name(), name, err)
+			continue
+		}
+		if reason != "" {
+			return reason
+		}
+	}
+	return ""
+}
+
+func quarantineFile(file, name, destination, username, scanner, reason string) (*QuarantineItem, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	os.MkdirAll(quarantineDir, os.ModePerm)
+	storagePath := filepath.Join(quarantineDir, newUploadSessionID())
+	if err := moveFile(file, storagePath); err != nil {
+		return nil, err
+	}
+	item := QuarantineItem{
+		Username:    username,
+		Name:        path.Base(filepath.ToSlash(name)),
+		Destination: destination,
+		Size:        info.Size(),
+		Scanner:     scanner,
+		Reason:      reason,
+		StoragePath: storagePath,
+	}
+	if err := db.Create(&item).Error; err != nil {
+		os.Remove(storagePath)
+		return nil, err
+	}
+	return &item, nil
+}
+
+// 检查目录中的所有文件，不安全的移入隔离区，返回被隔离的文件（相对路径）
+func scanUploadDir(dir, logicalDir, username string) ([]string, error) {
+	quarantined := []string{}
+	for _, f := range listPendingFiles(&PendingUpload{TempPath: dir}) {
+		file, err := safeJoin(dir, f.Path)
+		if err != nil {
+			continue
+		}
+		q, err := scanUpload(file, f.Path, joinSharedPath(logicalDir, f.Path), username)
+		if err != nil {
+			return nil, err
+		}
+		if q != nil {
+			quarantined = append(quarantined, f.Path)
+		}
+	}
+	return quarantined, nil
+}
+
+// 上传结果中关于隔离文件的提示
+func quarantineNotice(quarantined []string) string {
+	if len(quarantined) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("，其中 %d 个文件未通过安全检查，已被隔离等待管理员处理", len(quarantined))
+}
+
+func registerQuarantineRoutes(adminGroup *gin.RouterGroup) {
+	os.MkdirAll(quarantineDir, os.ModePerm)
+
+	adminGroup.GET("/scanner_settings", func(c *gin.Context) {
+		c.JSON(http.StatusOK, getScannerSettings())
+	})
+
+	adminGroup.POST("/scanner_settings", func(c *gin.Context) {
+		var settings ScannerSettings
+		if err := c.ShouldBindJSON(&settings); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+			return
+		}
+		if err := setScannerSettings(settings); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "保存失败"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "设置已保存", "settings": getScannerSettings()})
+	})
+
+	// 隔离区中的文件
+	adminGroup.GET("/quarantine", func(c *gin.Context) {
+		var items []QuarantineItem
+		db.Order("created_at desc").Find(&items)
+		c.JSON(http.StatusOK, items)
+	})
+
+	loadItem := func(c *gin.Context) (*QuarantineItem, bool) {
+		var item QuarantineItem
+		if err := db.Where("id = ?", c.Param("id")).First(&item).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "隔离记录不存在"})
+			return nil, false
+		}
+		return &item, true
+	}
+
+	// 确认安全后放行，导入到原本的位置（同名文件被覆盖，旧内容保留为历史版本）
+	adminGroup.POST("/quarantine/:id/release", func(c *gin.Context) {
+		item, ok := loadItem(c)
+		if !ok {
+			return
+		}
+		if item.Destination == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "该文件没有可放行的位置，只能删除"})
+			return
+		}
+		// 隔离期间目标位置可能发生变化，与审核通过时做同样的检查
+		if err := checkImportTarget(item.Username, item.Destination); err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "无法放行: " + err.Error()})
+			return
+		}
+		if isSharedDir(item.Destination) {
+			c.JSON(http.StatusConflict, gin.H{"error": "无法放行: 目标位置 " + item.Destination + " 现在是一个目录"})
+			return
+		}
+		if _, err := putSharedFile(item.StoragePath, item.Destination, item.Username); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "放行失败: " + err.Error()})
+			return
+		}
+		db.Delete(item)
+		c.JSON(http.StatusOK, gin.H{"message": "已放行", "path": item.Destination})
+	})
+
+	adminGroup.DELETE("/quarantine/:id", func(c *gin.Context) {
+		item, ok := loadItem(c)
+		if !ok {
+			return
+		}
+		os.Remove(item.StoragePath)
+		db.Delete(item)
+		c.JSON(http.StatusOK, gin.H{"message": "已删除"})
+	})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultSearchContentMaxSize = 1 << 20 // 超过该大小的文本文件不索引内容，可通过 Config 的 search_content_max_size 修改
+	searchResultLimit           = 200
+	searchSnippetRunes          = 40 // 内容匹配时关键字前后各保留的字符数
+)
+
+// 除了可预览的源码外，按 MIME 判断为文本的文件也索引内容
+func isIndexableText(name, mimeType string) bool {
+	if _, ok := previewLanguages[strings.ToLower(path.Ext(name))]; ok {
+		return true
+	}
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+	return strings.HasPrefix(mimeType, "text/") || mimeType == "application/json" || mimeType == "application/xml"
+}
+
+// 为内容建立全文索引。相同内容只索引一次，不是文本的也记录一条空内容，避免重复读取
+func indexSharedContent(hash, name, mimeType string, size int64) {
+	if !isIndexableText(name, mimeType) || size > getConfigInt("search_content_max_size", defaultSearchContentMaxSize) {
+		return
+	}
+	var count int64
+	db.Model(&SearchContent{}).Where("checksum = ?", hash).Count(&count)
+	if count > 0 {
+		return
+	}
+	content := ""
+	if f, err := openSharedBlob(&SharedItem{Checksum: hash}); err == nil {
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err == nil {
+			content, _ = decodeText(data)
+		}
+	}
+	db.Save(&SearchContent{Checksum: hash, Content: content})
+}
+
+// 为启用搜索前已有的文件补建索引
+func backfillSearchIndex() {
+	var items []SharedItem
+	db.Where("checksum NOT IN (?)", db.Model(&SearchContent{}).Select("checksum")).Find(&items)
+	for _, item := range items {
+		indexSharedContent(item.Checksum, item.Path, item.MIME, item.Size)
+	}
+	if len(items) > 0 {
+		log.Printf("搜索索引已更新，检查了 %d 个文件", len(items))
+	}
+}
+
+// 转义 LIKE 中的通配符
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// 截取关键字附近的一段内容
+func searchSnippet(content, q string) string {
+	lower := strings.ToLower(content)
+	i := strings.Index(lower, strings.ToLower(q))
+	if i < 0 || len(lower) != len(content) {
+		// 大小写转换改变了字节长度时无法对应位置，只返回开头
+		i = strings.Index(content, q)
+		if i < 0 {
+			i = 0
+		}
+	}
+	start := i
+	for n := 0; n < searchSnippetRunes && start > 0; n++ {
+		_, size := utf8.DecodeLastRuneInString(content[:start])
+		start -= size
+	}
+	end := i + len(q)
+	for n := 0; n < searchSnippetRunes && end < len(content); n++ {
+		_, size := utf8.DecodeRuneInString(content[end:])
+		end += size
+	}
+	snippet := strings.Join(strings.Fields(content[start:end]), " ")
+	if start > 0 {
+		snippet = "…" + snippet
+	}
+	if end < len(content) {
+		snippet += "…"
+	}
+	return snippet
+}
+
+func registerSearchRoutes(r *gin.Engine, authMiddleware gin.HandlerFunc) {
+	go backfillSearchIndex()
+
+	// 按文件名、所有者、扩展名和文本内容搜索共享文件，只返回当前用户可以访问的
+	r.GET("/api/files/search", authMiddleware, func(c *gin.Context) {
+		var user User
+		if err := db.Where("username = ?", c.MustGet("username").(string)).First(&user).Error; err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "用户不存在"})
+			return
+		}
+		q := strings.TrimSpace(c.Query("q"))
+		owner := c.Query("owner")
+		ext := strings.ToLower(strings.TrimPrefix(c.Query("ext"), "."))
+		if q == "" && owner == "" && ext == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "请输入搜索关键字"})
+			return
+		}
+
+		query := db.Model(&SharedItem{}).Select("shared_items.*").
+			Joins("LEFT JOIN search_contents ON search_contents.checksum = shared_items.checksum")
+		if q != "" {
+			pattern := "%" + escapeLike(q) + "%"
+			query = query.Where(`shared_items.path LIKE ? ESCAPE '\' OR search_contents.content LIKE ? ESCAPE '\'`, pattern, pattern)
+		}
+		if owner != "" {
+			query = query.Where("shared_items.owner = ?", owner)
+		}
+		if ext != "" {
+			query = query.Where(`lower(shared_items.path) LIKE ? ESCAPE '\'`, "%."+escapeLike(ext))
+		}
+		// 先按权限过滤再截取：分批读取，直到凑够结果或没有更多匹配
+		query = query.Order("shared_items.updated_at desc, shared_items.id desc").Session(&gorm.Session{})
+		acl := loadShareACL(&user)
+		var items []SharedItem
+		for offset := 0; len(items) < searchResultLimit; offset += searchResultLimit {
+			var batch []SharedItem
+			query.Offset(offset).Limit(searchResultLimit).Find(&batch)
+			items = append(items, acl.filterItems(batch)...)
+			if len(batch) < searchResultLimit {
+				break
+			}
+		}
+		if len(items) > searchResultLimit {
+			items = items[:searchResultLimit]
+		}
+
+		results := []gin.H{}
+		for _, item := range items {
+			e := sharedEntry{Name: path.Base(item.Path), Path: item.Path, Owner: item.Owner, Size: item.Size, ModTime: item.UpdatedAt, Item: &item}
+			result := gin.H(e.toJSON())
+			result["match"] = "name"
+			if q != "" && !strings.Contains(strings.ToLower(e.Name), strings.ToLower(q)) {
+				var content SearchContent
+				db.Where("checksum = ?", item.Checksum).First(&content)
+				if strings.Contains(strings.ToLower(content.Content), strings.ToLower(q)) {
+					result["match"] = "content"
+					result["snippet"] = searchSnippet(content.Content, q)
+				} else {
+					result["match"] = "path"
+				}
+			}
+			results = append(results, result)
+		}
+		c.JSON(http.StatusOK, results)
+	})
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 创建 n 个只有所有者能看到的文件，更新时间晚于 visible，排在搜索结果前面
+func createHiddenItems(t *testing.T, n int, visible time.Time) []SharedItem {
+	t.Helper()
+	db.Create(&ShareRule{Path: "bob_私有", Owner: "bob", Visibility: VisibilityUsers, Users: []string{"bob"}})
+	items := make([]SharedItem, n)
+	for i := range items {
+		items[i] = SharedItem{Owner: "bob", Path: fmt.Sprintf("bob_私有/报告%03d.txt", i), Checksum: "hidden", UpdatedAt: visible.Add(time.Hour)}
+	}
+	if err := db.Create(&items).Error; err != nil {
+		t.Fatal(err)
+	}
+	return items
+}
+
+// 排在前面的结果都不可见时，仍能找到后面可以访问的文件
+func TestSearchFiltersBeforeLimit(t *testing.T) {
+	setupTestStorage(t)
+	db.Create(&SearchContent{Checksum: "hidden"})
+	db.Create(&SearchContent{Checksum: "open"})
+	db.Create(&User{Username: "alice", Role: "user", Status: "active"})
+	now := time.Now()
+	createHiddenItems(t, searchResultLimit+5, now)
+	db.Create(&SharedItem{Owner: "carol", Path: "carol_公开/报告.txt", Checksum: "open", UpdatedAt: now})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerSearchRoutes(r, func(c *gin.Context) { c.Set("username", "alice") })
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/files/search?q=%E6%8A%A5%E5%91%8A", nil))
+
+	var results []map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0]["path"] != "carol_公开/报告.txt" {
+		t.Fatalf("results = %v", results)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// 分享链接最长有效期
+const shareLinkMaxLifetime = 365 * 24 * time.Hour
+
+// 每个IP对同一链接连续输错密码的次数限制
+var shareLinkLimiter = newIPRateLimiter(10, 10*time.Minute)
+
+// 校验分享链接，失败时已写入响应
+func loadShareLink(c *gin.Context) (*ShareLink, bool) {
+	var link ShareLink
+	if err := db.Where("token = ?", c.Param("token")).First(&link).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "分享链接不存在"})
+		return nil, false
+	}
+	if link.ExpiresAt != nil && time.Now().After(*link.ExpiresAt) {
+		c.JSON(http.StatusGone, gin.H{"error": "分享链接已过期"})
+		return nil, false
+	}
+	if link.Password == "" {
+		return &link, true
+	}
+
+	key := c.ClientIP() + "|" + link.Token
+	if shareLinkLimiter.exceeded(key) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "密码错误次数过多，请稍后再试"})
+		return nil, false
+	}
+	password := c.GetHeader("X-Share-Password")
+	if password == "" {
+		password = c.Query("password")
+	}
+	if password == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "需要输入提取密码", "needs_password": true})
+		return nil, false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(link.Password), []byte(password)) != nil {
+		shareLinkLimiter.allow(key)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "提取密码错误", "needs_password": true})
+		return nil, false
+	}
+	return &link, true
+}
+
+func shareLinkJSON(link *ShareLink) gin.H {
+	return gin.H{
+		"token":          link.Token,
+		"url":            "/api/s/" + link.Token,
+		"path":           link.Path,
+		"owner":          link.Owner,
+		"has_password":   link.Password != "",
+		"expires_at":     link.ExpiresAt,
+		"download_count": link.DownloadCount,
+		"created_at":     link.CreatedAt,
+	}
+}
+
+func registerShareLinkRoutes(r *gin.Engine, authMiddleware gin.HandlerFunc) {
+	// 创建分享链接（所有者或管理员）
+	r.POST("/api/share-links", authMiddleware, func(c *gin.Context) {
+		var req struct {
+			Path           string `json:"path" binding:"required"`
+			Password       string `json:"password"`
+			ExpiresInHours int    `json:"expires_in_hours"` // 0 表示永不过期
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+			return
+		}
+		_, logicalPath, ok := loadManagedSharedPath(c, req.Path)
+		if !ok {
+			return
+		}
+		if req.ExpiresInHours < 0 || time.Duration(req.ExpiresInHours)*time.Hour > shareLinkMaxLifetime {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "有效期无效，最长为365天"})
+			return
+		}
+
+		var item SharedItem
+		underSharedPath(db, logicalPath).First(&item)
+		link := ShareLink{
+			Token: newUploadSessionID(),
+			Owner: item.Owner,
+			Path:  logicalPath,
+		}
+		if req.ExpiresInHours > 0 {
+			expiresAt := time.Now().Add(time.Duration(req.ExpiresInHours) * time.Hour)
+			link.ExpiresAt = &expiresAt
+		}
+		if req.Password != "" {
+			hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "创建失败"})
+				return
+			}
+			link.Password = string(hash)
+		}
+		if err := db.Create(&link).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "创建失败"})
+			return
+		}
+		c.JSON(http.StatusOK, shareLinkJSON(&link))
+	})
+
+	// 列出自己创建的分享链接，可按路径筛选
+	r.GET("/api/share-links", authMiddleware, func(c *gin.Context) {
+		query := db.Where("owner = ?", c.MustGet("username").(string))
+		if p := c.Query("path"); p != "" {
+			logicalPath, err := resolveSharedPath(p)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "路径无效"})
+				return
+			}
+			query = query.Where("path = ?", logicalPath)
+		}
+		var links []ShareLink
+		query.Order("created_at desc").Find(&links)
+		result := []gin.H{}
+		for i := range links {
+			result = append(result, shareLinkJSON(&links[i]))
+		}
+		c.JSON(http.StatusOK, result)
+	})
+
+	// 取消分享链接（创建者或管理员）
+	r.DELETE("/api/share-links/:token", authMiddleware, func(c *gin.Context) {
+		username := c.MustGet("username").(string)
+		var user User
+		if err := db.Where("username = ?", username).First(&user).Error; err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "用户不存在"})
+			return
+		}
+		var link ShareLink
+		if err := db.Where("token = ?", c.Param("token")).First(&link).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "分享链接不存在"})
+			return
+		}
+		if link.Owner != username && !isAdmin(&user) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "只能取消自己的分享链接"})
+			return
+		}
+		db.Delete(&link)
+		c.JSON(http.StatusOK, gin.H{"message": "分享链接已取消"})
+	})
+
+	// 匿名查看分享内容
+	r.GET("/api/s/:token", func(c *gin.Context) {
+		link, ok := loadShareLink(c)
+		if !ok {
+			return
+		}
+		result := gin.H{
+			"name":       path.Base(link.Path),
+			"owner":      link.Owner,
+			"expires_at": link.ExpiresAt,
+		}
+		if item, err := findSharedFile(link.Path); err == nil {
+			result["is_dir"] = false
+			result["size"] = item.Size
+			result["mime"] = item.MIME
+			c.JSON(http.StatusOK, result)
+			return
+		}
+
+		items := sharedFilesUnder(link.Path)
+		if len(items) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "分享的文件已被删除"})
+			return
+		}
+		if !strings.Contains(link.Path, "/") {
+			result["name"] = strings.TrimPrefix(link.Path, link.Owner+"_")
+		}
+		files := []gin.H{}
+		var total int64
+		for _, item := range items {
+			total += item.Size
+			files = append(files, gin.H{
+				"path": strings.TrimPrefix(item.Path, link.Path+"/"),
+				"size": item.Size,
+				"mime": item.MIME,
+			})
+		}
+		result["is_dir"] = true
+		result["size"] = total
+		result["files"] = files
+		c.JSON(http.StatusOK, result)
+	})
+
+	// 匿名下载：分享的是文件时直接返回；是文件夹时可用 path 指定其中的文件，否则打包下载
+	r.GET("/api/s/:token/download", func(c *gin.Context) {
+		link, ok := loadShareLink(c)
+		if !ok {
+			return
+		}
+
+		target := link.Path
+		if rel := c.Query("path"); rel != "" {
+			rel, err := resolveSharedPath(rel)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "路径无效"})
+				return
+			}
+			target = joinSharedPath(link.Path, rel)
+		}
+
+		if item, err := findSharedFile(target); err == nil {
+			if c.GetHeader("Range") == "" {
+				db.Model(link).Update("download_count", gorm.Expr("download_count + 1"))
+			}
+			serveSharedFile(c, item, "link")
+			return
+		}
+
+		items := sharedFilesUnder(target)
+		if target == "" || len(items) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "文件不存在"})
+			return
+		}
+		zipName := path.Base(target)
+		if !strings.Contains(target, "/") {
+			zipName = strings.TrimPrefix(zipName, link.Owner+"_")
+		}
+		entries := make([]zipEntry, len(items))
+		for i := range items {
+			entries[i] = zipEntry{Item: &items[i], Name: strings.TrimPrefix(items[i].Path, target+"/")}
+		}
+		db.Model(link).Update("download_count", gorm.Expr("download_count + 1"))
+		serveSharedZip(c, zipName, entries, "link")
+	})
+}
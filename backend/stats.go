@@ -0,0 +1,169 @@
+package main
+
+import (
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPopularDays  = 7
+	popularResultLimit  = 20
+	downloadHistoryPage = 100
+)
+
+// downloaderStats 某个用户对一组文件的下载情况
+type downloaderStats struct {
+	Username     string    `json:"username"` // 为空表示通过分享链接下载
+	Downloads    int       `json:"downloads"`
+	Bytes        int64     `json:"bytes"`
+	LastDownload time.Time `json:"last_downloaded_at"`
+}
+
+func registerDownloadStatsRoutes(r *gin.Engine, authMiddleware gin.HandlerFunc, adminGroup *gin.RouterGroup) {
+	// 文件或目录的下载统计（所有者或管理员），包括每个文件的下载次数和每个用户的下载情况
+	r.GET("/api/files/stats", authMiddleware, func(c *gin.Context) {
+		_, logicalPath, ok := loadManagedSharedPath(c, c.Query("path"))
+		if !ok {
+			return
+		}
+		var items []SharedItem
+		underSharedPath(db, logicalPath).Order("path").Find(&items)
+		ids := make([]uint, len(items))
+		for i, item := range items {
+			ids[i] = item.ID
+		}
+		var records []DownloadRecord
+		if len(ids) > 0 {
+			db.Where("item_id IN ?", ids).Order("created_at").Find(&records)
+		}
+
+		counts := map[uint]int{}
+		byUser := map[string]*downloaderStats{}
+		var totalBytes int64
+		for _, rec := range records {
+			counts[rec.ItemID]++
+			totalBytes += rec.Bytes
+			s := byUser[rec.Username]
+			if s == nil {
+				s = &downloaderStats{Username: rec.Username}
+				byUser[rec.Username] = s
+			}
+			s.Downloads++
+			s.Bytes += rec.Bytes
+			s.LastDownload = rec.CreatedAt
+		}
+		users := make([]*downloaderStats, 0, len(byUser))
+		for _, s := range byUser {
+			users = append(users, s)
+		}
+		sort.Slice(users, func(i, j int) bool { return users[i].LastDownload.After(users[j].LastDownload) })
+
+		files := []gin.H{}
+		for _, item := range items {
+			files = append(files, gin.H{
+				"path":           item.Path,
+				"downloads":      counts[item.ID],
+				"download_count": item.DownloadCount, // 包括开始记录下载明细之前的次数
+			})
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"path":         logicalPath,
+			"downloads":    len(records),
+			"bytes":        totalBytes,
+			"unique_users": len(byUser),
+			"users":        users,
+			"files":        files,
+		})
+	})
+
+	// 最近一段时间（默认 7 天）下载最多的文件，只返回当前用户可以访问的
+	r.GET("/api/files/popular", authMiddleware, func(c *gin.Context) {
+		var user User
+		if err := db.Where("username = ?", c.MustGet("username").(string)).First(&user).Error; err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "用户不存在"})
+			return
+		}
+		days, err := strconv.Atoi(c.DefaultQuery("days", strconv.Itoa(defaultPopularDays)))
+		if err != nil || days <= 0 || days > 365 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+			return
+		}
+
+		type popularRow struct {
+			ItemID    uint
+			Downloads int
+			Users     int
+		}
+		query := db.Model(&DownloadRecord{}).
+			Select("item_id, count(*) as downloads, count(distinct username) as users").
+			Where("created_at >= ?", time.Now().AddDate(0, 0, -days)).
+			Group("item_id").Order("downloads desc, item_id desc").Session(&gorm.Session{})
+
+		// 先按权限过滤再截取：分批读取，直到凑够结果或没有更多下载记录
+		acl := loadShareACL(&user)
+		results := []gin.H{}
+		for offset := 0; len(results) < popularResultLimit; offset += searchResultLimit {
+			var rows []popularRow
+			query.Offset(offset).Limit(searchResultLimit).Scan(&rows)
+			ids := make([]uint, len(rows))
+			for i, row := range rows {
+				ids[i] = row.ItemID
+			}
+			var items []SharedItem
+			if len(ids) > 0 {
+				db.Where("id IN ?", ids).Find(&items)
+			}
+			visible := map[uint]*SharedItem{}
+			for _, item := range acl.filterItems(items) {
+				visible[item.ID] = &item
+			}
+
+			for _, row := range rows {
+				item := visible[row.ItemID]
+				if item == nil {
+					continue
+				}
+				e := sharedEntry{Name: path.Base(item.Path), Path: item.Path, Owner: item.Owner, Size: item.Size, ModTime: item.UpdatedAt, Item: item}
+				result := gin.H(e.toJSON())
+				result["recent_downloads"] = row.Downloads
+				result["recent_users"] = row.Users
+				results = append(results, result)
+				if len(results) == popularResultLimit {
+					break
+				}
+			}
+			if len(rows) < searchResultLimit {
+				break
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"days": days, "files": results})
+	})
+
+	// 下载记录，可按用户和路径筛选，用于查看某个用户的下载历史
+	adminGroup.GET("/downloads", func(c *gin.Context) {
+		query := db.Model(&DownloadRecord{})
+		if username, ok := c.GetQuery("username"); ok {
+			query = query.Where("username = ?", username)
+		}
+		if p := c.Query("path"); p != "" {
+			logicalPath, err := resolveSharedPath(p)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "路径无效"})
+				return
+			}
+			query = underSharedPath(query, logicalPath)
+		}
+		offset, _ := strconv.Atoi(c.Query("offset"))
+		var total int64
+		query.Count(&total)
+		var records []DownloadRecord
+		query.Order("created_at desc").Offset(max(offset, 0)).Limit(downloadHistoryPage).Find(&records)
+		c.JSON(http.StatusOK, gin.H{"total": total, "records": records})
+	})
+}
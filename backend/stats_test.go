@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 下载最多的文件都不可见时，仍能列出可以访问的热门文件
+func TestPopularFiltersBeforeLimit(t *testing.T) {
+	setupTestStorage(t)
+	db.Create(&User{Username: "alice", Role: "user", Status: "active"})
+	now := time.Now()
+	hidden := createHiddenItems(t, searchResultLimit+5, now)
+	var records []DownloadRecord
+	for _, item := range hidden {
+		records = append(records, DownloadRecord{Username: "bob", ItemID: item.ID}, DownloadRecord{Username: "bob", ItemID: item.ID})
+	}
+	open := SharedItem{Owner: "carol", Path: "carol_公开/报告.txt", UpdatedAt: now}
+	db.Create(&open)
+	records = append(records, DownloadRecord{Username: "alice", ItemID: open.ID})
+	if err := db.CreateInBatches(&records, 100).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerDownloadStatsRoutes(r, func(c *gin.Context) { c.Set("username", "alice") }, r.Group("/api/admin"))
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/files/popular", nil))
+
+	var resp struct {
+		Files []map[string]any `json:"files"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Files) != 1 || resp.Files[0]["path"] != "carol_公开/报告.txt" {
+		t.Fatalf("files = %v", resp.Files)
+	}
+}
@@ -0,0 +1,533 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 共享文件采用内容寻址存储：文件内容按 SHA-256 存放在 blobs 目录，相同内容只存一份；
+// SharedItem 表记录逻辑路径（如 alice_课件/第一章.pdf）到内容的映射，列表、下载、删除都通过该表完成。
+const (
+	blobDir    = "./blobs"
+	sharedDir  = "./shared"         // 旧版本直接存放文件的目录，启动时会迁移到 blob 存储
+	stagingDir = "./upload_staging" // 上传文件导入 blob 存储前的暂存目录
+)
+
+// 内容对应的 blob 文件路径，按哈希前两位分目录避免单目录文件过多
+func blobPath(hash string) string {
+	return filepath.Join(blobDir, hash[:2], hash)
+}
+
+// 逻辑路径统一使用 / 分隔，不带首尾斜杠
+func joinSharedPath(parts ...string) string {
+	var nonEmpty []string
+	for _, p := range parts {
+		p = strings.Trim(strings.ReplaceAll(p, "\\", "/"), "/")
+		if p != "" {
+			nonEmpty = append(nonEmpty, p)
+		}
+	}
+	return strings.Join(nonEmpty, "/")
+}
+
+// 查询 prefix 目录下（含子目录）的所有条目。使用区间比较而不是 LIKE，
+// 既能走索引又避免了通配符和大小写问题（'0' 是 '/' 的下一个字符）
+func underSharedPath(tx *gorm.DB, prefix string) *gorm.DB {
+	if prefix == "" {
+		return tx
+	}
+	return tx.Where("path = ? OR (path >= ? AND path < ?)", prefix, prefix+"/", prefix+"0")
+}
+
+// 同一内容的存入和释放必须串行，否则释放时判断未被引用之后、删除之前，
+// 另一个上传可能正好存入相同内容并建立引用，文件随后被删掉。按哈希分段加锁
+var blobLocks [64]sync.Mutex
+
+func lockBlob(hash string) func() {
+	n, _ := strconv.ParseUint(hash[:2], 16, 8)
+	m := &blobLocks[n%uint64(len(blobLocks))]
+	m.Lock()
+	return m.Unlock
+}
+
+// 将文件移入 blob 存储，内容已存在时直接删除源文件，返回内容哈希和大小。
+// 成功时该内容保持加锁，调用方建立引用（写入数据库）后再调用返回的 unlock
+func storeBlob(src string) (hash string, size int64, unlock func(), err error) {
+	if hash, err = fileSHA256(src); err != nil {
+		return "", 0, nil, err
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	unlock = lockBlob(hash)
+	dst := blobPath(hash)
+	if _, statErr := os.Stat(dst); statErr == nil {
+		err = os.Remove(src)
+	} else {
+		err = moveFile(src, dst)
+	}
+	if err != nil {
+		unlock()
+		return "", 0, nil, err
+	}
+	return hash, info.Size(), unlock, nil
+}
+
+// 识别文件的 MIME 类型：优先按扩展名，无法识别时按内容嗅探
+func detectMIME(path, name string) string {
+	if t := mime.TypeByExtension(strings.ToLower(filepath.Ext(name))); t != "" {
+		return t
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "application/octet-stream"
+	}
+	defer f.Close()
+	buf := make([]byte, 512)
+	n, _ := f.Read(buf)
+	return http.DetectContentType(buf[:n])
+}
+
+// 判断 blob 是否仍被引用
+func blobInUse(hash string) bool {
+	var shared, attachments, versions, trashed int64
+	db.Model(&SharedItem{}).Where("checksum = ?", hash).Count(&shared)
+	db.Model(&Attachment{}).Where("checksum = ?", hash).Count(&attachments)
+	db.Model(&FileVersion{}).Where("checksum = ?", hash).Count(&versions)
+	db.Model(&TrashedBlob{}).Where("checksum = ?", hash).Count(&trashed)
+	return shared+attachments+versions+trashed > 0
+}
+
+// 删除不再被任何共享文件、历史版本、聊天附件或回收站引用的 blob 及其缩略图、预览和搜索索引
+func releaseBlobs(hashes ...string) {
+	for _, hash := range hashes {
+		if hash == "" {
+			continue
+		}
+		unlock := lockBlob(hash)
+		if !blobInUse(hash) {
+			os.Remove(blobPath(hash))
+			os.Remove(thumbnailPath(hash))
+			removePreviews(hash)
+			db.Where("checksum = ?", hash).Delete(&SearchContent{})
+		}
+		unlock()
+	}
+}
+
+// 目标位置已有其他用户的文件
+var errNotOwner = errors.New("目标位置已有其他用户的文件")
+
+// 将本地文件保存到共享空间的 logicalPath，已存在同名文件时覆盖（保留原有说明和下载次数），原内容保存为历史版本。
+// 已存在的文件属于其他用户时返回 errNotOwner，此时不会移动 src
+func putSharedFile(src, logicalPath, owner string) (*SharedItem, error) {
+	if existing, err := findSharedFile(logicalPath); err == nil && existing.Owner != owner {
+		return nil, errNotOwner
+	}
+	mimeType := detectMIME(src, logicalPath)
+	hash, size, unlock, err := storeBlob(src)
+	if err != nil {
+		return nil, err
+	}
+
+	var item SharedItem
+	oldHash := ""
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("path = ?", logicalPath).First(&item).Error; err == nil {
+			if item.Owner != owner {
+				return errNotOwner
+			}
+			oldHash = item.Checksum
+			if oldHash != hash {
+				if err := saveFileVersion(tx, &item); err != nil {
+					return err
+				}
+			}
+			item.Owner = owner
+			item.Checksum = hash
+			item.Size = size
+			item.MIME = mimeType
+			item.CRC32 = nil
+			return tx.Save(&item).Error
+		}
+		item = SharedItem{Owner: owner, Path: logicalPath, Checksum: hash, Size: size, MIME: mimeType}
+		return tx.Create(&item).Error
+	})
+	unlock()
+	if err != nil {
+		releaseBlobs(hash)
+		return nil, err
+	}
+	if oldHash != "" && oldHash != hash {
+		releaseBlobs(oldHash)
+		pruneFileVersions(item.Owner)
+	}
+	indexSharedContent(hash, logicalPath, mimeType, size)
+	queuePreview(hash, logicalPath, mimeType)
+	return &item, nil
+}
+
+// 将本地目录中的所有文件导入到共享空间的 logicalDir 下，完成后删除源目录
+func importSharedDir(srcDir, logicalDir, owner string) error {
+	err := filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+		_, err = putSharedFile(path, joinSharedPath(logicalDir, filepath.ToSlash(rel)), owner)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(srcDir)
+}
+
+// 删除共享空间中的文件或目录，返回删除的文件数（只有空目录时为删除的目录数）。owner 不为空时只删除该用户拥有的文件
+func removeSharedPath(logicalPath, owner string) (int, error) {
+	if logicalPath == "" {
+		return 0, fmt.Errorf("不能删除共享根目录")
+	}
+	query := func() *gorm.DB {
+		q := underSharedPath(db, logicalPath)
+		if owner != "" {
+			q = q.Where("owner = ?", owner)
+		}
+		return q
+	}
+	folders := underSharedPath(db, logicalPath)
+	if owner != "" {
+		folders = folders.Where("owner = ?", owner)
+	}
+	folderCount := folders.Delete(&SharedFolder{}).RowsAffected
+
+	var items []SharedItem
+	query().Find(&items)
+	if len(items) == 0 {
+		if folderCount > 0 {
+			dropShareSettings(logicalPath)
+		}
+		return int(folderCount), nil
+	}
+	if err := query().Delete(&SharedItem{}).Error; err != nil {
+		return 0, err
+	}
+	hashes := make([]string, 0, len(items))
+	for _, item := range items {
+		hashes = append(hashes, item.Checksum)
+	}
+	releaseBlobs(hashes...)
+	dropFileVersions(logicalPath)
+	dropShareSettings(logicalPath)
+	return len(items), nil
+}
+
+// 查询单个文件
+func findSharedFile(logicalPath string) (*SharedItem, error) {
+	var item SharedItem
+	if err := db.Where("path = ?", logicalPath).First(&item).Error; err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// 查询目录下的所有文件，按路径排序
+func sharedFilesUnder(logicalDir string) []SharedItem {
+	var items []SharedItem
+	underSharedPath(db, logicalDir).Order("path asc").Find(&items)
+	return items
+}
+
+// 判断逻辑路径是否是一个目录（包含文件或手动创建）
+func isSharedDir(logicalDir string) bool {
+	var count int64
+	db.Model(&SharedItem{}).Where("path >= ? AND path < ?", logicalDir+"/", logicalDir+"0").Count(&count)
+	if count > 0 {
+		return true
+	}
+	underSharedPath(db.Model(&SharedFolder{}), logicalDir).Count(&count)
+	return count > 0
+}
+
+// 判断逻辑路径上是否已有文件或目录
+func sharedPathExists(logicalPath string) bool {
+	if _, err := findSharedFile(logicalPath); err == nil {
+		return true
+	}
+	return isSharedDir(logicalPath)
+}
+
+// sharedEntry 目录列表中的一项
+type sharedEntry struct {
+	Name    string
+	Path    string
+	IsDir   bool
+	Owner   string
+	Size    int64 // 目录为其中所有文件的总大小
+	ModTime time.Time
+	Item    *SharedItem // 文件对应的元数据，目录为 nil
+}
+
+// 目录列表项转为接口返回的 JSON
+func (e *sharedEntry) toJSON() map[string]interface{} {
+	result := map[string]interface{}{
+		"name":       e.Name,
+		"path":       e.Path,
+		"is_dir":     e.IsDir,
+		"owner":      e.Owner,
+		"size":       e.Size,
+		"updated_at": e.ModTime,
+	}
+	if e.Item != nil {
+		result["mime"] = e.Item.MIME
+		result["checksum"] = e.Item.Checksum
+		result["description"] = e.Item.Description
+		result["download_count"] = e.Item.DownloadCount
+		result["created_at"] = e.Item.CreatedAt
+	}
+	return result
+}
+
+// 根目录下的各个顶层目录。直接在数据库中按顶层名称汇总，不读出整张表；
+// 所有者与子目录一样取其中路径最小的那个文件，按该路径回表查出，不依赖聚合查询中普通列的取值
+func listSharedRoot() (map[string]*sharedEntry, []string) {
+	var rows []struct {
+		Name      string
+		FirstPath string
+		Owner     string
+		Size      int64
+		ModTime   int64 // 聚合后的时间列没有类型信息，按 Unix 时间取出
+	}
+	summary := db.Model(&SharedItem{}).
+		Select("CASE WHEN instr(path, '/') > 0 THEN substr(path, 1, instr(path, '/') - 1) ELSE path END AS name, " +
+			"MIN(path) AS first_path, SUM(size) AS size, MAX(CAST(strftime('%s', updated_at) AS INTEGER)) AS mod_time").
+		Group("name")
+	db.Table("(?) AS t", summary).
+		Select("t.name, t.first_path, t.size, t.mod_time, shared_items.owner").
+		Joins("JOIN shared_items ON shared_items.path = t.first_path").
+		Order("t.name").Scan(&rows)
+
+	entries := map[string]*sharedEntry{}
+	var order []string
+	for _, row := range rows {
+		e := &sharedEntry{Name: row.Name, Path: row.Name, IsDir: row.FirstPath != row.Name, Owner: row.Owner, Size: row.Size, ModTime: time.Unix(row.ModTime, 0)}
+		if !e.IsDir {
+			// 直接放在根目录下的文件（旧数据）
+			if item, err := findSharedFile(row.Name); err == nil {
+				e.Item = item
+				e.ModTime = item.UpdatedAt
+			}
+		}
+		entries[row.Name] = e
+		order = append(order, row.Name)
+	}
+	return entries, order
+}
+
+// 子目录下的直接子项
+func listSharedSubdir(logicalDir string) (map[string]*sharedEntry, []string) {
+	var items []SharedItem
+	db.Where("path >= ? AND path < ?", logicalDir+"/", logicalDir+"0").Order("path asc").Find(&items)
+
+	entries := map[string]*sharedEntry{}
+	var order []string
+	for i, item := range items {
+		rel := strings.TrimPrefix(item.Path, logicalDir+"/")
+		name, _, isDir := strings.Cut(rel, "/")
+		e, ok := entries[name]
+		if !ok {
+			e = &sharedEntry{Name: name, Path: joinSharedPath(logicalDir, name), IsDir: isDir, Owner: item.Owner}
+			if !isDir {
+				e.Item = &items[i]
+			}
+			entries[name] = e
+			order = append(order, name)
+		}
+		e.Size += item.Size
+		if item.UpdatedAt.After(e.ModTime) {
+			e.ModTime = item.UpdatedAt
+		}
+	}
+	return entries, order
+}
+
+// 列出目录的直接子项，目录在前，同类按名称排序
+func listSharedDir(logicalDir string) []sharedEntry {
+	var entries map[string]*sharedEntry
+	var order []string
+	if logicalDir == "" {
+		entries, order = listSharedRoot()
+	} else {
+		entries, order = listSharedSubdir(logicalDir)
+	}
+
+	// 手动创建的空目录
+	var folders []SharedFolder
+	if logicalDir == "" {
+		db.Order("path asc").Find(&folders)
+	} else {
+		db.Where("path >= ? AND path < ?", logicalDir+"/", logicalDir+"0").Order("path asc").Find(&folders)
+	}
+	for _, folder := range folders {
+		rel := folder.Path
+		if logicalDir != "" {
+			rel = strings.TrimPrefix(folder.Path, logicalDir+"/")
+		}
+		name, _, _ := strings.Cut(rel, "/")
+		if _, ok := entries[name]; !ok {
+			entries[name] = &sharedEntry{Name: name, Path: joinSharedPath(logicalDir, name), IsDir: true, Owner: folder.Owner, ModTime: folder.CreatedAt}
+			order = append(order, name)
+		}
+	}
+
+	result := make([]sharedEntry, 0, len(order))
+	for _, name := range order {
+		result = append(result, *entries[name])
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].IsDir != result[j].IsDir {
+			return result[i].IsDir
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// 打开共享文件的内容
+func openSharedBlob(item *SharedItem) (*os.File, error) {
+	return os.Open(blobPath(item.Checksum))
+}
+
+// 将旧版本直接存放在 shared 目录下的文件迁移到 blob 存储并建立索引
+func migrateSharedDir() {
+	entries, err := os.ReadDir(sharedDir)
+	if err != nil {
+		return
+	}
+	migrated := 0
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		err := filepath.WalkDir(filepath.Join(sharedDir, e.Name()), func(path string, d fs.DirEntry, err error) error {
+			if err != nil || !d.Type().IsRegular() {
+				return nil
+			}
+			rel, err := filepath.Rel(sharedDir, path)
+			if err != nil {
+				return nil
+			}
+			logicalPath, err := resolveSharedPath(filepath.ToSlash(rel))
+			if err != nil {
+				log.Printf("跳过名称不合法的共享文件 %s: %v", path, err)
+				return nil
+			}
+			if _, err := putSharedFile(path, logicalPath, ownerOfTopLevel(e.Name())); err != nil {
+				log.Printf("迁移共享文件失败 %s: %v", path, err)
+				return nil
+			}
+			migrated++
+			return nil
+		})
+		if err == nil {
+			removeEmptyDirs(filepath.Join(sharedDir, e.Name()))
+		}
+	}
+	if migrated > 0 {
+		log.Printf("已将 %d 个共享文件迁移到去重存储", migrated)
+	}
+}
+
+// 根据顶层目录名 <username>_<folder> 推断所有者。用户名本身可能包含下划线，
+// 因此取能匹配上的最长用户名，而不是简单地按第一个下划线切分
+func ownerOfTopLevel(name string) string {
+	var usernames []string
+	db.Unscoped().Model(&User{}).Pluck("username", &usernames)
+	owner := ""
+	for _, u := range usernames {
+		if strings.HasPrefix(name, u+"_") && len(u) > len(owner) {
+			owner = u
+		}
+	}
+	if owner == "" {
+		// 找不到对应用户（如已被删除）时退回旧的切分规则
+		owner, _, _ = strings.Cut(name, "_")
+	}
+	return owner
+}
+
+// 为旧版本迁移过来、还没有所有者和类型信息的记录补全元数据
+func backfillSharedItems() {
+	var items []SharedItem
+	db.Where("owner = '' OR owner IS NULL OR mime = '' OR mime IS NULL").Find(&items)
+	for _, item := range items {
+		updates := map[string]interface{}{}
+		if item.Owner == "" {
+			top, _, _ := strings.Cut(item.Path, "/")
+			updates["owner"] = ownerOfTopLevel(top)
+		}
+		if item.MIME == "" {
+			updates["mime"] = detectMIME(blobPath(item.Checksum), item.Path)
+		}
+		db.Model(&SharedItem{}).Where("id = ?", item.ID).Updates(updates)
+	}
+}
+
+// 记录一次下载。username 为空表示通过分享链接下载
+func recordSharedDownload(item *SharedItem, username, source string, bytes int64) {
+	db.Model(&SharedItem{}).Where("id = ?", item.ID).Update("download_count", gorm.Expr("download_count + 1"))
+	db.Create(&DownloadRecord{Username: username, ItemID: item.ID, Path: item.Path, Owner: item.Owner, Bytes: bytes, Source: source})
+}
+
+// 自底向上删除空目录
+func removeEmptyDirs(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			removeEmptyDirs(filepath.Join(dir, e.Name()))
+		}
+	}
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) == 0 {
+		os.Remove(dir)
+	}
+}
+
+// 初始化存储目录并迁移旧数据
+func initSharedStorage() {
+	os.MkdirAll(blobDir, os.ModePerm)
+	os.MkdirAll(sharedDir, os.ModePerm)
+	// 暂存目录中的内容都是上次异常退出遗留的，直接清空
+	os.RemoveAll(stagingDir)
+	os.MkdirAll(stagingDir, os.ModePerm)
+	startPreviewWorkers()
+	migrateSharedDir()
+	backfillSharedItems()
+}
+
+// 创建一个上传暂存目录
+func newStagingDir() (string, error) {
+	return os.MkdirTemp(stagingDir, "upload-")
+}
@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+// 根目录的所有者取自路径最小的文件，而不是组内任意一行
+func TestListSharedRootOwnerFromFirstPath(t *testing.T) {
+	setupTestStorage(t)
+	db.Create(&SharedItem{Owner: "bob", Path: "alice_课件/z.txt", Size: 2})
+	db.Create(&SharedItem{Owner: "alice", Path: "alice_课件/a.txt", Size: 3})
+	db.Create(&SharedItem{Owner: "carol", Path: "alice_课件/m.txt", Size: 4})
+
+	entries, order := listSharedRoot()
+	if len(order) != 1 || order[0] != "alice_课件" {
+		t.Fatalf("order = %v", order)
+	}
+	e := entries["alice_课件"]
+	if e.Owner != "alice" || e.Size != 9 || !e.IsDir {
+		t.Fatalf("entry = %+v", e)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+)
+
+const (
+	thumbnailDir       = "./thumbnails" // 缩略图缓存，按内容哈希命名，内容不变缩略图就不变
+	thumbnailSize      = 320            // 缩略图最长边
+	thumbnailMaxPixels = 40 << 20       // 原图最大像素数，防止解压炸弹
+)
+
+// 可以生成缩略图的图片格式
+var thumbnailTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+}
+
+func thumbnailPath(hash string) string {
+	return filepath.Join(thumbnailDir, hash+".png")
+}
+
+// 确保内容为 hash 的图片已有缩略图，返回缩略图路径
+func ensureThumbnail(hash, mimeType string) (string, error) {
+	dst := thumbnailPath(hash)
+	if _, err := os.Stat(dst); err == nil {
+		return dst, nil
+	}
+	if !thumbnailTypes[mimeType] {
+		return "", fmt.Errorf("不支持的图片格式")
+	}
+
+	f, err := os.Open(blobPath(hash))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return "", err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > thumbnailMaxPixels {
+		return "", fmt.Errorf("图片尺寸过大")
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return "", err
+	}
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return "", err
+	}
+
+	os.MkdirAll(thumbnailDir, os.ModePerm)
+	tmp, err := os.CreateTemp(thumbnailDir, "tmp-*")
+	if err != nil {
+		return "", err
+	}
+	if err := png.Encode(tmp, fitThumbnail(src, thumbnailSize)); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	tmp.Close()
+	// 先写临时文件再改名，并发生成同一张缩略图时不会读到半个文件
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return dst, nil
+}
+
+// 按比例缩小到最长边不超过 size（小图保持原尺寸），每个目标像素取对应区域内若干采样点的平均值
+func fitThumbnail(src image.Image, size int) *image.RGBA {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > size || h > size {
+		if w >= h {
+			w, h = size, max(1, h*size/b.Dx())
+		} else {
+			w, h = max(1, w*size/b.Dy()), size
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	const samples = 4
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var r, g, bl, a uint32
+			for sy := 0; sy < samples; sy++ {
+				for sx := 0; sx < samples; sx++ {
+					px := b.Min.X + (x*samples+sx)*b.Dx()/(w*samples)
+					py := b.Min.Y + (y*samples+sy)*b.Dy()/(h*samples)
+					c := color.RGBAModel.Convert(src.At(px, py)).(color.RGBA)
+					r += uint32(c.R)
+					g += uint32(c.G)
+					bl += uint32(c.B)
+					a += uint32(c.A)
+				}
+			}
+			const n = samples * samples
+			dst.SetRGBA(x, y, color.RGBA{uint8(r / n), uint8(g / n), uint8(bl / n), uint8(a / n)})
+		}
+	}
+	return dst
+}
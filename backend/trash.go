@@ -0,0 +1,483 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	trashDir = "./trash" // 被拒绝的待审核文件移到这里，共享文件的内容仍在 blob 存储中
+	// 删除的文件在回收站中保留的默认天数，可通过 Config 的 trash_retention_days 修改，0 表示不使用回收站直接删除
+	defaultTrashRetentionDays = 30
+)
+
+// trashedFile 回收站中的一个文件，保存恢复所需的全部元数据
+type trashedFile struct {
+	Path          string    `json:"path"`
+	Owner         string    `json:"owner"`
+	Checksum      string    `json:"checksum"`
+	Size          int64     `json:"size"`
+	MIME          string    `json:"mime"`
+	Description   string    `json:"description"`
+	DownloadCount int64     `json:"download_count"`
+	CRC32         *uint32   `json:"crc32,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// 记录回收站中的文件引用的 blob
+func recordTrashedBlobs(tx *gorm.DB, entry *TrashEntry) error {
+	blobs := make([]TrashedBlob, 0, len(entry.Files))
+	for _, f := range entry.Files {
+		if f.Checksum != "" {
+			blobs = append(blobs, TrashedBlob{TrashID: entry.ID, Checksum: f.Checksum})
+		}
+	}
+	if len(blobs) == 0 {
+		return nil
+	}
+	return tx.Create(&blobs).Error
+}
+
+// 为之前的版本创建、还没有 blob 引用记录的回收站内容补上记录
+func backfillTrashedBlobs() {
+	var entries []TrashEntry
+	db.Where("kind = ? AND id NOT IN (?)", "shared", db.Model(&TrashedBlob{}).Select("trash_id")).Find(&entries)
+	for i := range entries {
+		if err := recordTrashedBlobs(db, &entries[i]); err != nil {
+			log.Printf("补全回收站引用记录失败 %s: %v", entries[i].Path, err)
+		}
+	}
+}
+
+func trashRetention() time.Duration {
+	return time.Duration(getConfigInt("trash_retention_days", defaultTrashRetentionDays)) * 24 * time.Hour
+}
+
+func trashEnabled() bool {
+	return trashRetention() > 0
+}
+
+// 删除共享空间中的文件或目录：启用回收站时移入回收站，否则直接删除。
+// owner 不为空时只删除该用户拥有的内容，返回删除的文件数（只有空目录时为目录数）
+func trashSharedPath(logicalPath, owner, deletedBy string) (int, error) {
+	if !trashEnabled() {
+		return removeSharedPath(logicalPath, owner)
+	}
+	scoped := func(tx *gorm.DB) *gorm.DB {
+		q := underSharedPath(tx, logicalPath)
+		if owner != "" {
+			q = q.Where("owner = ?", owner)
+		}
+		return q
+	}
+	var items []SharedItem
+	scoped(db).Order("path asc").Find(&items)
+	var folders []SharedFolder
+	scoped(db).Find(&folders)
+	if len(items) == 0 && len(folders) == 0 {
+		return 0, nil
+	}
+
+	entry := TrashEntry{Owner: owner, Path: logicalPath, DeletedBy: deletedBy, Files: []trashedFile{}, Folders: []string{}}
+	if entry.Owner == "" {
+		entry.Owner = ownerOfTopLevel(sharedTopLevel(logicalPath))
+	}
+	for _, item := range items {
+		entry.Size += item.Size
+		entry.Files = append(entry.Files, trashedFile{
+			Path:          item.Path,
+			Owner:         item.Owner,
+			Checksum:      item.Checksum,
+			Size:          item.Size,
+			MIME:          item.MIME,
+			Description:   item.Description,
+			DownloadCount: item.DownloadCount,
+			CRC32:         item.CRC32,
+			CreatedAt:     item.CreatedAt,
+		})
+	}
+	for _, folder := range folders {
+		entry.Folders = append(entry.Folders, folder.Path)
+	}
+	paths := make([]string, 0, len(items))
+	for _, item := range items {
+		paths = append(paths, item.Path)
+	}
+	// 先写回收站记录再删除，blob 在整个过程中都有引用，不会被提前清理
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&entry).Error; err != nil {
+			return err
+		}
+		if err := recordTrashedBlobs(tx, &entry); err != nil {
+			return err
+		}
+		if err := trashFileVersions(tx, paths, entry.ID); err != nil {
+			return err
+		}
+		if err := scoped(tx).Delete(&SharedItem{}).Error; err != nil {
+			return err
+		}
+		return scoped(tx).Delete(&SharedFolder{}).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	dropShareSettings(logicalPath)
+	if len(items) == 0 {
+		return len(folders), nil
+	}
+	return len(items), nil
+}
+
+// 将回收站中的共享文件恢复到原位置，conflict 的含义与移动、复制相同：
+// 自动改名时整体恢复到一个新名称下
+func restoreTrashedFiles(entry *TrashEntry, conflict string) (*transferResult, error) {
+	root := entry.Path
+	if conflict == ConflictRename {
+		isFile := len(entry.Files) == 1 && entry.Files[0].Path == entry.Path
+		root = uniqueSharedPath(root, isFile)
+	}
+	target := func(p string) string {
+		return root + strings.TrimPrefix(p, entry.Path)
+	}
+
+	result := &transferResult{Path: root}
+	conflicts := map[string]bool{}
+	for _, f := range entry.Files {
+		newPath := target(f.Path)
+		for p := sharedParent(newPath); p != ""; p = sharedParent(p) {
+			if _, err := findSharedFile(p); err == nil {
+				return nil, &transferError{http.StatusConflict, "原位置 " + p + " 现在是一个文件，无法恢复"}
+			}
+		}
+		if isSharedDir(newPath) {
+			return nil, &transferError{http.StatusConflict, "原位置 " + newPath + " 现在是一个目录，无法恢复"}
+		}
+		if _, err := findSharedFile(newPath); err == nil {
+			conflicts[newPath] = true
+			result.Conflicts = append(result.Conflicts, newPath)
+		}
+	}
+	for _, folder := range entry.Folders {
+		if _, err := findSharedFile(target(folder)); err == nil {
+			return nil, &transferError{http.StatusConflict, "原位置 " + target(folder) + " 现在是一个文件，无法恢复"}
+		}
+	}
+	if len(result.Conflicts) > 0 && conflict == "" {
+		return result, &transferError{http.StatusConflict, "原位置已有同名文件，请选择覆盖、跳过或自动改名"}
+	}
+	result.Conflicts = nil
+
+	var released, overwritten []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, f := range entry.Files {
+			newPath := target(f.Path)
+			if conflicts[newPath] {
+				if conflict == ConflictSkip {
+					result.Skipped = append(result.Skipped, newPath)
+					continue
+				}
+				var old SharedItem
+				if err := tx.Where("path = ?", newPath).First(&old).Error; err != nil {
+					return err
+				}
+				// 与上传覆盖一样，被覆盖的内容保存为历史版本
+				if err := saveFileVersion(tx, &old); err != nil {
+					return err
+				}
+				if err := tx.Delete(&old).Error; err != nil {
+					return err
+				}
+				released = append(released, old.Checksum)
+				overwritten = append(overwritten, old.Owner)
+			}
+			item := SharedItem{
+				CreatedAt:     f.CreatedAt,
+				Owner:         f.Owner,
+				Path:          newPath,
+				Checksum:      f.Checksum,
+				Size:          f.Size,
+				MIME:          f.MIME,
+				Description:   f.Description,
+				DownloadCount: f.DownloadCount,
+				CRC32:         f.CRC32,
+			}
+			if err := tx.Create(&item).Error; err != nil {
+				return err
+			}
+			if err := restoreFileVersions(tx, entry.ID, f.Path, newPath); err != nil {
+				return err
+			}
+			result.Count++
+		}
+		for _, folder := range entry.Folders {
+			var count int64
+			tx.Model(&SharedFolder{}).Where("path = ?", target(folder)).Count(&count)
+			if count == 0 {
+				if err := tx.Create(&SharedFolder{Owner: entry.Owner, Path: target(folder)}).Error; err != nil {
+					return err
+				}
+			}
+		}
+		if err := tx.Where("trash_id = ?", entry.ID).Delete(&TrashedBlob{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(entry).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	// 跳过的文件随回收站记录一起删除
+	for _, p := range result.Skipped {
+		for _, f := range entry.Files {
+			if target(f.Path) == p {
+				released = append(released, f.Checksum)
+			}
+		}
+	}
+	releaseBlobs(released...)
+	// 跳过的文件的历史版本同样删除
+	purgeFileVersions(entry.ID)
+	pruneOwnersVersions(overwritten)
+	return result, nil
+}
+
+// 将被拒绝的文件放回待审核队列
+func restoreTrashedUpload(entry *TrashEntry) error {
+	var pending PendingUpload
+	if err := db.First(&pending, entry.UploadID).Error; err != nil {
+		return &transferError{http.StatusNotFound, "对应的上传记录已不存在"}
+	}
+	if err := mergeDir(entry.StoragePath, pending.TempPath); err != nil {
+		return err
+	}
+	os.RemoveAll(entry.StoragePath)
+	restored := map[string]bool{}
+	for _, f := range entry.Files {
+		restored[f.Path] = true
+	}
+	rejected := []string{}
+	for _, p := range pending.RejectedFiles {
+		if !restored[p] {
+			rejected = append(rejected, p)
+		}
+	}
+	pending.RejectedFiles = rejected
+	pending.TotalSize += entry.Size
+	pending.Status = "pending"
+	if err := db.Save(&pending).Error; err != nil {
+		return err
+	}
+	return db.Delete(entry).Error
+}
+
+// 彻底删除回收站记录，内容不再被引用时一并删除
+func purgeTrashEntry(entry *TrashEntry) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("trash_id = ?", entry.ID).Delete(&TrashedBlob{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(entry).Error
+	})
+	if err != nil {
+		return
+	}
+	hashes := make([]string, 0, len(entry.Files))
+	for _, f := range entry.Files {
+		hashes = append(hashes, f.Checksum)
+	}
+	releaseBlobs(hashes...)
+	purgeFileVersions(entry.ID)
+	if entry.StoragePath != "" {
+		os.RemoveAll(entry.StoragePath)
+	}
+}
+
+// 定期清除超过保留期的回收站记录。保留天数为 0 时回收站停用，已有的记录保留到重新启用或手动删除，
+// 否则截止时间就是当前时间，会把回收站中的内容全部清除
+func cleanupTrash() {
+	for {
+		if retention := trashRetention(); retention > 0 {
+			var entries []TrashEntry
+			db.Where("created_at < ?", time.Now().Add(-retention)).Find(&entries)
+			for i := range entries {
+				log.Printf("清除回收站中过期的内容: %s (%s)", entries[i].Path, entries[i].Owner)
+				purgeTrashEntry(&entries[i])
+			}
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+func trashEntryJSON(entry *TrashEntry) gin.H {
+	var expiresAt *time.Time // 回收站停用时不会过期
+	if retention := trashRetention(); retention > 0 {
+		t := entry.CreatedAt.Add(retention)
+		expiresAt = &t
+	}
+	return gin.H{
+		"id":         entry.ID,
+		"kind":       entry.Kind,
+		"owner":      entry.Owner,
+		"path":       entry.Path,
+		"deleted_by": entry.DeletedBy,
+		"deleted_at": entry.CreatedAt,
+		"expires_at": expiresAt,
+		"size":       entry.Size,
+		"files":      entry.Files,
+		"folders":    entry.Folders,
+	}
+}
+
+func registerTrashRoutes(r *gin.Engine, authMiddleware gin.HandlerFunc, adminGroup *gin.RouterGroup) {
+	os.MkdirAll(trashDir, os.ModePerm)
+	backfillTrashedBlobs()
+	go cleanupTrash()
+
+	// 加载回收站记录，普通用户只能操作自己的
+	loadTrashEntry := func(c *gin.Context) (*User, *TrashEntry, bool) {
+		var user User
+		if err := db.Where("username = ?", c.MustGet("username").(string)).First(&user).Error; err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "用户不存在"})
+			return nil, nil, false
+		}
+		var entry TrashEntry
+		if err := db.Where("id = ?", c.Param("id")).First(&entry).Error; err != nil || (entry.Owner != user.Username && !isAdmin(&user)) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "回收站中没有该记录"})
+			return nil, nil, false
+		}
+		return &user, &entry, true
+	}
+
+	// 查看回收站：普通用户看到自己的内容，管理员看到全部，可按 owner 筛选
+	r.GET("/api/trash", authMiddleware, func(c *gin.Context) {
+		var user User
+		if err := db.Where("username = ?", c.MustGet("username").(string)).First(&user).Error; err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "用户不存在"})
+			return
+		}
+		query := db.Order("created_at desc")
+		if !isAdmin(&user) {
+			query = query.Where("owner = ?", user.Username)
+		} else if owner := c.Query("owner"); owner != "" {
+			query = query.Where("owner = ?", owner)
+		}
+		var entries []TrashEntry
+		query.Find(&entries)
+		result := []gin.H{}
+		for i := range entries {
+			result = append(result, trashEntryJSON(&entries[i]))
+		}
+		c.JSON(http.StatusOK, result)
+	})
+
+	// 恢复。共享文件恢复到原位置，conflict 为 overwrite / skip / rename；被拒绝的文件重新进入待审核（仅管理员）
+	r.POST("/api/trash/:id/restore", authMiddleware, func(c *gin.Context) {
+		user, entry, ok := loadTrashEntry(c)
+		if !ok {
+			return
+		}
+		var req struct {
+			Conflict string `json:"conflict"`
+		}
+		c.ShouldBindJSON(&req)
+		if !isValidConflictMode(req.Conflict) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+			return
+		}
+
+		if entry.Kind == "upload" {
+			if !isAdmin(user) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "被拒绝的文件只能由管理员恢复"})
+				return
+			}
+			if err := restoreTrashedUpload(entry); err != nil {
+				if te, ok := err.(*transferError); ok {
+					c.JSON(te.Status, gin.H{"error": te.Msg})
+					return
+				}
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "恢复失败"})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"message": "已重新加入待审核列表"})
+			return
+		}
+
+		// 回收站中的内容已计入用量，恢复时不需要再检查配额
+		result, err := restoreTrashedFiles(entry, req.Conflict)
+		if err != nil {
+			if te, ok := err.(*transferError); ok {
+				resp := gin.H{"error": te.Msg}
+				if result != nil {
+					resp["conflicts"] = result.Conflicts
+				}
+				c.JSON(te.Status, resp)
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "恢复失败"})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	})
+
+	// 从回收站中彻底删除
+	r.DELETE("/api/trash/:id", authMiddleware, func(c *gin.Context) {
+		_, entry, ok := loadTrashEntry(c)
+		if !ok {
+			return
+		}
+		purgeTrashEntry(entry)
+		c.JSON(http.StatusOK, gin.H{"message": "已彻底删除"})
+	})
+
+	// 回收站保留天数
+	adminGroup.GET("/trash_settings", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"retention_days": getConfigInt("trash_retention_days", defaultTrashRetentionDays)})
+	})
+
+	adminGroup.POST("/trash_settings", func(c *gin.Context) {
+		var req struct {
+			RetentionDays *int64 `json:"retention_days" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || *req.RetentionDays < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+			return
+		}
+		if err := setConfigValue("trash_retention_days", strconv.FormatInt(*req.RetentionDays, 10)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "保存失败"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "设置已保存"})
+	})
+
+	// 删除单个文件或子目录（所有者或管理员）。permanent=1 时跳过回收站直接删除
+	r.DELETE("/api/files", authMiddleware, func(c *gin.Context) {
+		user, logicalPath, ok := loadManagedSharedPath(c, c.Query("path"))
+		if !ok {
+			return
+		}
+		owner := user.Username
+		if isAdmin(user) {
+			owner = ""
+		}
+		var count int
+		var err error
+		if c.Query("permanent") == "1" {
+			count, err = removeSharedPath(logicalPath, owner)
+		} else {
+			count, err = trashSharedPath(logicalPath, owner, user.Username)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "删除失败"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "删除成功", "count": count, "trashed": trashEnabled() && c.Query("permanent") != "1"})
+	})
+}
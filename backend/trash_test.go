@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 回收站中的文件按哈希记录引用，彻底删除前 blob 不会被清理
+func TestTrashedBlobKeepsContentUntilPurge(t *testing.T) {
+	setupTestStorage(t)
+	hash := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	os.MkdirAll(filepath.Dir(blobPath(hash)), os.ModePerm)
+	os.WriteFile(blobPath(hash), []byte("x"), 0644)
+	db.Create(&SharedItem{Owner: "alice", Path: "alice_课件/a.txt", Checksum: hash, Size: 1})
+
+	if _, err := trashSharedPath("alice_课件/a.txt", "alice", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	releaseBlobs(hash)
+	if _, err := os.Stat(blobPath(hash)); err != nil {
+		t.Fatalf("回收站中的内容被提前删除: %v", err)
+	}
+
+	var entry TrashEntry
+	if err := db.First(&entry).Error; err != nil {
+		t.Fatal(err)
+	}
+	purgeTrashEntry(&entry)
+	if _, err := os.Stat(blobPath(hash)); !os.IsNotExist(err) {
+		t.Fatalf("彻底删除后内容仍然存在: %v", err)
+	}
+	var count int64
+	db.Model(&TrashedBlob{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("引用记录未删除: %d", count)
+	}
+}
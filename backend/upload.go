@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 上传文件夹（需登录）
+func registerUploadFolderRoute(r *gin.Engine, authMiddleware gin.HandlerFunc) {
+	r.POST("/api/upload-folder", authMiddleware, func(c *gin.Context) {
+		username := c.MustGet("username").(string)
+
+		// 检查权限
+		var user User
+		if err := db.Where("username = ?", username).First(&user).Error; err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "用户不存在"})
+			return
+		}
+		if !user.CanShareFiles && user.Role == "user" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "您已被禁止共享文件"})
+			return
+		}
+
+		folderName := c.PostForm("folderName")
+		if folderName == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "文件夹名不能为空"})
+			return
+		}
+		folderName, err := cleanPathSegment(folderName)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "文件夹名无效: " + err.Error()})
+			return
+		}
+
+		form, err := c.MultipartForm()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无法解析表单"})
+			return
+		}
+		files := form.File["files"]
+		paths := form.Value["paths"]
+
+		logicalDir := fmt.Sprintf("%s_%s", username, folderName)
+		if err := checkUploadTarget(username, logicalDir); err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+
+		// 先校验所有文件的相对路径，任何一个不合法都拒绝整个上传
+		relPaths := make([]string, len(files))
+		for i, file := range files {
+			relPath := file.Filename
+			if i < len(paths) {
+				relPath = paths[i]
+			}
+			relPath, err := cleanRelPath(relPath)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "文件路径无效: " + err.Error()})
+				return
+			}
+			// 第一段是顶层文件夹名，去掉后即文件在共享文件夹中的位置
+			if _, rest, ok := strings.Cut(relPath, "/"); ok {
+				relPath = rest
+			} else if relPath, err = cleanPathSegment(file.Filename); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "文件名无效: " + err.Error()})
+				return
+			}
+			relPaths[i] = relPath
+		}
+
+		// 计算总大小
+		var totalSize int64 = 0
+		var entries []uploadEntry
+		for i, file := range files {
+			totalSize += file.Size
+			// 按实际保存的路径判断文件类型，而不是客户端随意填写的文件名
+			entries = append(entries, uploadEntry{Name: relPaths[i], Size: file.Size})
+		}
+
+		decision := evaluateUploadPolicy(&user, entries)
+		if decision.Blocked {
+			c.JSON(http.StatusForbidden, gin.H{"error": decision.Reason})
+			return
+		}
+
+		if status, err := checkStorage(&user, totalSize); err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		needsApproval := decision.NeedsReview
+
+		var destDir string
+		if needsApproval {
+			// 需要审核时放入临时目录，等admin审核
+			tempFolderName := fmt.Sprintf("%d_%s_%s", time.Now().Unix(), username, folderName)
+			destDir = "./temp_uploads/" + tempFolderName
+			os.MkdirAll(destDir, os.ModePerm)
+		} else {
+			// 先保存到暂存目录，再导入去重存储
+			destDir, err = newStagingDir()
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "保存文件失败"})
+				return
+			}
+		}
+
+		for i, file := range files {
+			destPath, err := safeJoin(destDir, relPaths[i])
+			if err != nil {
+				os.RemoveAll(destDir)
+				c.JSON(http.StatusBadRequest, gin.H{"error": "文件路径无效: " + err.Error()})
+				return
+			}
+			os.MkdirAll(filepath.Dir(destPath), os.ModePerm)
+			c.SaveUploadedFile(file, destPath)
+		}
+
+		// 安全检查，未通过的文件移入隔离区
+		quarantined, err := scanUploadDir(destDir, logicalDir, username)
+		if err != nil {
+			os.RemoveAll(destDir)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if len(quarantined) > 0 && len(quarantined) == len(files) {
+			os.RemoveAll(destDir)
+			c.JSON(http.StatusOK, gin.H{"message": "文件未通过安全检查，已被隔离等待管理员处理", "status": "quarantined", "quarantined": quarantined})
+			return
+		}
+
+		if needsApproval {
+			if len(quarantined) > 0 {
+				totalSize = 0
+				for _, f := range listPendingFiles(&PendingUpload{TempPath: destDir}) {
+					totalSize += f.Size
+				}
+			}
+			db.Create(&PendingUpload{
+				Username:   username,
+				FolderName: folderName,
+				TotalSize:  totalSize,
+				Status:     "pending",
+				TempPath:   destDir,
+				ReviewRule: decision.Rule,
+			})
+			c.JSON(http.StatusOK, gin.H{"message": "上传成功，由于" + decision.Reason + "，正在等待管理员审核" + quarantineNotice(quarantined), "status": "pending", "quarantined": quarantined})
+			return
+		}
+
+		if err := importSharedDir(destDir, logicalDir, username); err != nil {
+			os.RemoveAll(destDir)
+			if errors.Is(err, errNotOwner) {
+				c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "保存文件失败"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "上传成功" + quarantineNotice(quarantined), "status": "approved", "quarantined": quarantined})
+	})
+}
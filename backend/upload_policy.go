@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// UploadPolicy 上传审核策略，以 JSON 存储在 Config 的 upload_policy 中，管理员可随时调整
+type UploadPolicy struct {
+	SizeThreshold     int64    `json:"size_threshold"`     // 总大小超过该值需要审核（字节），0 表示不按大小审核
+	BlockedExtensions []string `json:"blocked_extensions"` // 禁止上传的扩展名，如 .exe
+	ReviewExtensions  []string `json:"review_extensions"`  // 包含这些扩展名的上传需要审核
+	AutoApproveRoles  []string `json:"auto_approve_roles"` // 这些角色的上传跳过审核（禁止的扩展名仍然生效）
+	ReviewAllRoles    []string `json:"review_all_roles"`   // 这些角色的所有上传都需要审核
+}
+
+// 默认策略与旧版本行为一致：超过 150MB 需要审核
+var defaultUploadPolicy = UploadPolicy{
+	SizeThreshold:     157286400,
+	BlockedExtensions: []string{},
+	ReviewExtensions:  []string{},
+	AutoApproveRoles:  []string{},
+	ReviewAllRoles:    []string{},
+}
+
+// 上传策略的判定结果
+type uploadDecision struct {
+	Blocked     bool   // 禁止上传
+	NeedsReview bool   // 需要管理员审核
+	Rule        string // 触发的规则，如 size>150.0 MB、extension:.exe、role:user
+	Reason      string // 给用户看的说明
+}
+
+// 待判定的上传文件
+type uploadEntry struct {
+	Name string
+	Size int64
+}
+
+func getUploadPolicy() UploadPolicy {
+	policy := defaultUploadPolicy
+	if raw := getConfigValue("upload_policy", ""); raw != "" {
+		json.Unmarshal([]byte(raw), &policy)
+	}
+	return policy
+}
+
+// 统一扩展名格式：小写并带前导点，去掉空项和重复项
+func normalizeExtensions(exts []string) []string {
+	result := []string{}
+	seen := map[string]bool{}
+	for _, ext := range exts {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		if !seen[ext] {
+			seen[ext] = true
+			result = append(result, ext)
+		}
+	}
+	return result
+}
+
+func setUploadPolicy(policy UploadPolicy) error {
+	policy.BlockedExtensions = normalizeExtensions(policy.BlockedExtensions)
+	policy.ReviewExtensions = normalizeExtensions(policy.ReviewExtensions)
+	if policy.AutoApproveRoles == nil {
+		policy.AutoApproveRoles = []string{}
+	}
+	if policy.ReviewAllRoles == nil {
+		policy.ReviewAllRoles = []string{}
+	}
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	return setConfigValue("upload_policy", string(data))
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// 按当前策略判定一次上传，优先级：禁止扩展名 > 角色自动通过 > 角色全部审核 > 审核扩展名 > 大小阈值
+func evaluateUploadPolicy(user *User, entries []uploadEntry) uploadDecision {
+	policy := getUploadPolicy()
+	blocked := normalizeExtensions(policy.BlockedExtensions)
+	review := normalizeExtensions(policy.ReviewExtensions)
+
+	var totalSize int64
+	reviewExt := ""
+	for _, e := range entries {
+		ext := strings.ToLower(filepath.Ext(e.Name))
+		if ext != "" && containsString(blocked, ext) {
+			return uploadDecision{
+				Blocked: true,
+				Rule:    "extension:" + ext,
+				Reason:  fmt.Sprintf("不允许上传 %s 类型的文件", ext),
+			}
+		}
+		if reviewExt == "" && ext != "" && containsString(review, ext) {
+			reviewExt = ext
+		}
+		totalSize += e.Size
+	}
+
+	if containsString(policy.AutoApproveRoles, user.Role) {
+		return uploadDecision{}
+	}
+	if containsString(policy.ReviewAllRoles, user.Role) {
+		return uploadDecision{NeedsReview: true, Rule: "role:" + user.Role, Reason: "当前策略要求所有上传都经过审核"}
+	}
+	if reviewExt != "" {
+		return uploadDecision{NeedsReview: true, Rule: "extension:" + reviewExt, Reason: fmt.Sprintf("包含 %s 类型的文件", reviewExt)}
+	}
+	if policy.SizeThreshold > 0 && totalSize > policy.SizeThreshold {
+		return uploadDecision{
+			NeedsReview: true,
+			Rule:        "size>" + formatBytes(policy.SizeThreshold),
+			Reason:      fmt.Sprintf("文件超过%s", formatBytes(policy.SizeThreshold)),
+		}
+	}
+	return uploadDecision{}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// 在临时目录中建立测试用的数据库和存储目录
+func setupTestStorage(t *testing.T) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	var err error
+	db, err = gorm.Open(sqlite.Open(filepath.Join(".", "test.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&User{}, &Config{}, &PendingUpload{}, &UploadSession{}, &SharedItem{}, &SharedFolder{},
+		&FileVersion{}, &SearchContent{}, &TrashEntry{}, &TrashedBlob{}, &QuarantineItem{}, &Attachment{},
+		&ShareRule{}, &ShareLink{}, &DownloadRecord{}); err != nil {
+		t.Fatal(err)
+	}
+	initSharedStorage()
+}
+
+// 禁止的扩展名按文件实际保存的路径判断，multipart 中的文件名与 paths 不一致时不能绕过
+func TestUploadFolderChecksPolicyAgainstStoredPath(t *testing.T) {
+	setupTestStorage(t)
+	db.Create(&User{Username: "alice", Role: "user", CanShareFiles: true, Status: "active"})
+	policy := defaultUploadPolicy
+	policy.BlockedExtensions = []string{".exe"}
+	if err := setUploadPolicy(policy); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerUploadFolderRoute(r, func(c *gin.Context) { c.Set("username", "alice") })
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	w.WriteField("folderName", "x")
+	part, _ := w.CreateFormFile("files", "harmless.txt")
+	part.Write([]byte("MZ"))
+	w.WriteField("paths", "x/evil.exe")
+	w.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/upload-folder", &body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusForbidden {
+		t.Fatalf("状态码 %d，期望 403: %s", resp.Code, resp.Body.String())
+	}
+	var count int64
+	db.Model(&SharedItem{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("被禁止的文件不应保存，实际有 %d 个", count)
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 每个用户最多保留的历史版本数（所有文件合计），可通过 Config 的 max_file_versions 修改，0 表示不保留历史版本
+const defaultMaxFileVersions = 50
+
+// 把文件当前的内容记为一个历史版本，覆盖前调用
+func saveFileVersion(tx *gorm.DB, item *SharedItem) error {
+	if getConfigInt("max_file_versions", defaultMaxFileVersions) <= 0 {
+		return nil
+	}
+	return tx.Create(&FileVersion{
+		Path:       item.Path,
+		Owner:      item.Owner,
+		Checksum:   item.Checksum,
+		Size:       item.Size,
+		MIME:       item.MIME,
+		ModifiedAt: item.UpdatedAt,
+	}).Error
+}
+
+// 超出上限时删除用户最旧的历史版本
+func pruneFileVersions(owner string) {
+	limit := getConfigInt("max_file_versions", defaultMaxFileVersions)
+	var versions []FileVersion
+	db.Where("owner = ?", owner).Order("created_at desc, id desc").Offset(int(limit)).Find(&versions)
+	if len(versions) == 0 {
+		return
+	}
+	hashes := make([]string, 0, len(versions))
+	for _, v := range versions {
+		db.Delete(&v)
+		hashes = append(hashes, v.Checksum)
+	}
+	releaseBlobs(hashes...)
+}
+
+// 覆盖了多个用户的文件后，分别检查各自的历史版本数
+func pruneOwnersVersions(owners []string) {
+	seen := map[string]bool{}
+	for _, owner := range owners {
+		if !seen[owner] {
+			seen[owner] = true
+			pruneFileVersions(owner)
+		}
+	}
+}
+
+// 文件移入回收站时，其历史版本一起移入
+func trashFileVersions(tx *gorm.DB, paths []string, trashID uint) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	return tx.Model(&FileVersion{}).Where("path IN ? AND trash_id = 0", paths).Update("trash_id", trashID).Error
+}
+
+// 从回收站恢复文件时，历史版本随文件回到恢复后的路径（自动改名时路径会变化）
+func restoreFileVersions(tx *gorm.DB, trashID uint, oldPath, newPath string) error {
+	return tx.Model(&FileVersion{}).Where("trash_id = ? AND path = ?", trashID, oldPath).
+		Updates(map[string]interface{}{"path": newPath, "trash_id": 0}).Error
+}
+
+// 删除回收站记录中剩余的历史版本，内容不再被引用时一并删除
+func purgeFileVersions(trashID uint) {
+	var versions []FileVersion
+	db.Where("trash_id = ?", trashID).Find(&versions)
+	if len(versions) == 0 {
+		return
+	}
+	hashes := make([]string, 0, len(versions))
+	for _, v := range versions {
+		db.Delete(&v)
+		hashes = append(hashes, v.Checksum)
+	}
+	releaseBlobs(hashes...)
+}
+
+// 删除路径下已不存在的文件的历史版本
+func dropFileVersions(logicalPath string) {
+	var versions []FileVersion
+	underSharedPath(db, logicalPath).Where("trash_id = 0").Find(&versions)
+	var hashes []string
+	for _, v := range versions {
+		if _, err := findSharedFile(v.Path); err == nil {
+			continue
+		}
+		db.Delete(&v)
+		hashes = append(hashes, v.Checksum)
+	}
+	releaseBlobs(hashes...)
+}
+
+func fileVersionJSON(v *FileVersion) gin.H {
+	return gin.H{
+		"id":          v.ID,
+		"size":        v.Size,
+		"checksum":    v.Checksum,
+		"mime":        v.MIME,
+		"modified_at": v.ModifiedAt,
+		"replaced_at": v.CreatedAt,
+	}
+}
+
+func registerVersionRoutes(r *gin.Engine, authMiddleware gin.HandlerFunc, adminGroup *gin.RouterGroup) {
+	// 加载文件及其某个历史版本（所有者或管理员）
+	loadVersion := func(c *gin.Context, rawPath string, id uint) (*SharedItem, *FileVersion, bool) {
+		_, logicalPath, ok := loadManagedSharedPath(c, rawPath)
+		if !ok {
+			return nil, nil, false
+		}
+		item, err := findSharedFile(logicalPath)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "只有文件才有历史版本"})
+			return nil, nil, false
+		}
+		var version FileVersion
+		if err := db.Where("id = ? AND path = ? AND trash_id = 0", id, item.Path).First(&version).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "该版本不存在"})
+			return nil, nil, false
+		}
+		return item, &version, true
+	}
+
+	// 列出文件的历史版本，最新的在前
+	r.GET("/api/files/versions", authMiddleware, func(c *gin.Context) {
+		_, logicalPath, ok := loadManagedSharedPath(c, c.Query("path"))
+		if !ok {
+			return
+		}
+		item, err := findSharedFile(logicalPath)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "只有文件才有历史版本"})
+			return
+		}
+		var versions []FileVersion
+		db.Where("path = ? AND trash_id = 0", item.Path).Order("created_at desc, id desc").Find(&versions)
+		result := []gin.H{}
+		for i := range versions {
+			result = append(result, fileVersionJSON(&versions[i]))
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"current": gin.H{
+				"size":        item.Size,
+				"checksum":    item.Checksum,
+				"mime":        item.MIME,
+				"modified_at": item.UpdatedAt,
+			},
+			"versions": result,
+		})
+	})
+
+	// 下载某个历史版本
+	r.GET("/api/files/versions/:id", authMiddleware, func(c *gin.Context) {
+		id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+		item, version, ok := loadVersion(c, c.Query("path"), uint(id))
+		if !ok {
+			return
+		}
+		f, err := os.Open(blobPath(version.Checksum))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "该版本不存在"})
+			return
+		}
+		defer f.Close()
+		c.Header("ETag", `"`+version.Checksum+`"`)
+		c.Header("Content-Type", version.MIME)
+		c.Header("X-Content-Type-Options", "nosniff")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(item.Path)))
+		http.ServeContent(c.Writer, c.Request, path.Base(item.Path), version.ModifiedAt, f)
+	})
+
+	// 恢复到某个历史版本，当前内容成为一个新的历史版本
+	r.POST("/api/files/versions/restore", authMiddleware, func(c *gin.Context) {
+		var req struct {
+			Path string `json:"path" binding:"required"`
+			ID   uint   `json:"id" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+			return
+		}
+		item, version, ok := loadVersion(c, req.Path, req.ID)
+		if !ok {
+			return
+		}
+		// 与覆盖上传一样，只计算恢复后文件增加的大小，计入文件所有者的配额
+		var owner User
+		if err := db.Where("username = ?", item.Owner).First(&owner).Error; err == nil {
+			if status, err := checkStorage(&owner, max(version.Size-item.Size, 0)); err != nil {
+				c.JSON(status, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := saveFileVersion(tx, item); err != nil {
+				return err
+			}
+			if err := tx.Delete(version).Error; err != nil {
+				return err
+			}
+			item.Checksum = version.Checksum
+			item.Size = version.Size
+			item.MIME = version.MIME
+			item.CRC32 = nil
+			return tx.Save(item).Error
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "恢复失败"})
+			return
+		}
+		pruneFileVersions(item.Owner)
+		queuePreview(item.Checksum, item.Path, item.MIME)
+		c.JSON(http.StatusOK, gin.H{"message": "已恢复到所选版本", "checksum": item.Checksum, "size": item.Size})
+	})
+
+	adminGroup.GET("/version_settings", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"max_file_versions": getConfigInt("max_file_versions", defaultMaxFileVersions)})
+	})
+
+	// 修改每个用户保留的历史版本数，调小后立即清理超出的旧版本
+	adminGroup.POST("/version_settings", func(c *gin.Context) {
+		var req struct {
+			MaxFileVersions *int64 `json:"max_file_versions" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || *req.MaxFileVersions < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+			return
+		}
+		if err := setConfigValue("max_file_versions", strconv.FormatInt(*req.MaxFileVersions, 10)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "保存失败"})
+			return
+		}
+		var owners []string
+		db.Model(&FileVersion{}).Distinct().Pluck("owner", &owners)
+		for _, owner := range owners {
+			pruneFileVersions(owner)
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "设置已保存"})
+	})
+}
@@ -15,6 +15,8 @@ api.interceptors.request.use(config => {
     return config
 })
 
+const downloadToken = () => encodeURIComponent(localStorage.getItem('airchat_token') || '')
+
 export const authApi = {
     register: (data: any) => api.post('/register', data),
     login: (data: any) => api.post('/login', data),
@@ -33,13 +35,48 @@ export const fileApi = {
     getMyFolders: () => api.get('/my-folders'),
     getSharedFolders: (path?: string) => api.get('/shared-folders', { params: { path } }),
     deleteFolder: (name: string) => api.delete(`/delete-folder/${encodeURIComponent(name)}`),
-    downloadFolder: (path: string) => `${API_BASE}/download-folder?path=${encodeURIComponent(path)}`,
+    deleteFile: (path: string, permanent = false) => api.delete('/files', { params: { path, permanent: permanent ? 1 : undefined } }),
+    // conflict: overwrite / skip / rename，不指定时遇到同名文件返回 409
+    mkdir: (parent: string, name: string) => api.post('/files/mkdir', { parent, name }),
+    rename: (path: string, name: string, conflict?: string) => api.post('/files/rename', { path, name, conflict }),
+    move: (path: string, to: string, conflict?: string) => api.post('/files/move', { path, to, conflict }),
+    copy: (path: string, to: string, conflict?: string) => api.post('/files/copy', { path, to, conflict }),
+    search: (params: { q?: string, owner?: string, ext?: string }) => api.get('/files/search', { params }),
+    getDownloadStats: (path: string) => api.get('/files/stats', { params: { path } }),
+    getPopular: (days = 7) => api.get('/files/popular', { params: { days } }),
+    getVersions: (path: string) => api.get('/files/versions', { params: { path } }),
+    restoreVersion: (path: string, id: number) => api.post('/files/versions/restore', { path, id }),
+    downloadVersion: (path: string, id: number) => `${API_BASE}/files/versions/${id}?path=${encodeURIComponent(path)}&token=${downloadToken()}`,
+    // 预览尚未生成时返回 202，稍后重试
+    getPreview: (path: string) => api.get('/preview', { params: { path } }),
+    previewThumbnail: (path: string) => `${API_BASE}/preview?path=${encodeURIComponent(path)}&thumbnail=1&token=${downloadToken()}`,
+    // 浏览器直接打开的下载链接无法附带请求头，通过 token 参数鉴权
+    downloadFile: (path: string) => `http://${window.location.hostname}:8080/shared/${encodeURIComponent(path)}?token=${downloadToken()}`,
+    downloadFolder: (path: string) => `${API_BASE}/download-folder?path=${encodeURIComponent(path)}&token=${downloadToken()}`,
     batchDownload: (paths: string[]) => {
         const query = paths.map(p => `paths=${encodeURIComponent(p)}`).join('&')
-        return `${API_BASE}/batch-download?${query}`
+        return `${API_BASE}/batch-download?${query}&token=${downloadToken()}`
     }
 }
 
+export const notificationApi = {
+    list: (unread = false) => api.get('/notifications', { params: { unread: unread ? 1 : undefined } }),
+    markRead: (ids?: number[]) => api.post('/notifications/read', { ids })
+}
+
+// 应用专用密码（用于 WebDAV 客户端登录 /dav），密码只在创建时返回一次
+export const appPasswordApi = {
+    list: () => api.get('/app-passwords'),
+    create: (name: string) => api.post('/app-passwords', { name }),
+    revoke: (id: number) => api.delete(`/app-passwords/${id}`)
+}
+
+export const trashApi = {
+    list: (owner?: string) => api.get('/trash', { params: { owner } }),
+    restore: (id: number, conflict?: string) => api.post(`/trash/${id}/restore`, { conflict }),
+    purge: (id: number) => api.delete(`/trash/${id}`)
+}
+
 export const adminApi = {
     getUsers: () => api.get('/admin/users'),
     muteUser: (data: { username: string, is_muted: boolean }) => api.post('/admin/mute', data),
@@ -52,9 +89,25 @@ export const adminApi = {
     deleteUser: (username: string) => api.delete(`/admin/users/${username}`),
     togglePermission: (data: { username: string, permission: string, value: boolean }) => api.post('/admin/toggle_permission', data),
     getPendingUploads: () => api.get('/admin/pending_uploads'),
-    approveUpload: (id: number) => api.post('/admin/approve_upload', { id }),
-    rejectUpload: (id: number) => api.post('/admin/reject_upload', { id }),
-    deleteSharedFile: (path: string) => api.delete(`/admin/delete-shared?path=${encodeURIComponent(path)}`)
+    getPendingUploadFiles: (id: number) => api.get(`/admin/pending_uploads/${id}/files`),
+    // files 为空时处理全部剩余文件
+    approveUpload: (id: number, files?: string[], reason?: string, conflict?: string) => api.post('/admin/approve_upload', { id, files, reason, conflict }),
+    rejectUpload: (id: number, files?: string[], reason?: string) => api.post('/admin/reject_upload', { id, files, reason }),
+    deleteSharedFile: (path: string) => api.delete(`/admin/delete-shared?path=${encodeURIComponent(path)}`),
+    getTrashSettings: () => api.get('/admin/trash_settings'),
+    setTrashSettings: (retention_days: number) => api.post('/admin/trash_settings', { retention_days }),
+    getVersionSettings: () => api.get('/admin/version_settings'),
+    setVersionSettings: (max_file_versions: number) => api.post('/admin/version_settings', { max_file_versions }),
+    getScannerSettings: () => api.get('/admin/scanner_settings'),
+    setScannerSettings: (settings: { block_executables: boolean, block_scripts: boolean, script_extensions: string[], clamd_address: string }) =>
+        api.post('/admin/scanner_settings', settings),
+    getQuarantine: () => api.get('/admin/quarantine'),
+    releaseQuarantine: (id: number) => api.post(`/admin/quarantine/${id}/release`),
+    deleteQuarantine: (id: number) => api.delete(`/admin/quarantine/${id}`),
+    getJanitorSettings: () => api.get('/admin/janitor_settings'),
+    setJanitorSettings: (pending_expire_days: number) => api.post('/admin/janitor_settings', { pending_expire_days }),
+    runJanitor: () => api.post('/admin/janitor/run'),
+    getDownloads: (params: { username?: string, path?: string, offset?: number }) => api.get('/admin/downloads', { params })
 }
 
 export default api